@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// adminAPI は稼働中のroomとクライアントを確認・操作するためのJSON APIです。
+//
+//	GET    /admin/rooms                         roomの一覧
+//	GET    /admin/rooms/{room}/clients          接続中のクライアントの一覧
+//	DELETE /admin/rooms/{room}/clients/{id}     クライアントを切断する
+//	POST   /admin/rooms/{room}/broadcast        お知らせを送る {"message": "..."}
+//	DELETE /admin/users/{userid}                ユーザーの接続をすべて切断する
+//
+// サーバーが持つroomは/roomの1つだけで、閉じると作り直せないため、
+// roomを閉じる操作はroomを追加できるようになるまで見送っています。
+type adminAPI struct {
+	rooms map[string]*room
+}
+
+func newAdminAPI(rooms ...*room) *adminAPI {
+	a := &adminAPI{rooms: map[string]*room{}}
+	for _, r := range rooms {
+		a.rooms[r.name] = r
+	}
+	return a
+}
+
+type adminRoom struct {
+	Name    string `json:"name"`
+	Clients int    `json:"clients"`
+}
+
+type adminClient struct {
+	ID        uint64 `json:"id"`
+	UserID    string `json:"userid"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+}
+
+func (a *adminAPI) room(name string) *room {
+	return a.rooms[name]
+}
+
+func (a *adminAPI) allRooms() []*room {
+	rooms := make([]*room, 0, len(a.rooms))
+	for _, r := range a.rooms {
+		rooms = append(rooms, r)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].name < rooms[j].name })
+	return rooms
+}
+
+func (a *adminAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segs := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin"), "/"), "/")
+	switch {
+	case len(segs) == 1 && segs[0] == "rooms" && r.Method == http.MethodGet:
+		a.handleRooms(w, r)
+	case len(segs) == 3 && segs[0] == "rooms" && segs[2] == "clients" && r.Method == http.MethodGet:
+		a.handleClients(w, r, segs[1])
+	case len(segs) == 4 && segs[0] == "rooms" && segs[2] == "clients" && r.Method == http.MethodDelete:
+		a.handleDisconnectClient(w, r, segs[1], segs[3])
+	case len(segs) == 3 && segs[0] == "rooms" && segs[2] == "broadcast" && r.Method == http.MethodPost:
+		a.handleBroadcast(w, r, segs[1])
+	case len(segs) == 2 && segs[0] == "users" && r.Method == http.MethodDelete:
+		a.handleDisconnectUser(w, r, segs[1])
+	default:
+		respondHTTPErr(w, r, http.StatusNotFound)
+	}
+}
+
+func (a *adminAPI) handleRooms(w http.ResponseWriter, r *http.Request) {
+	result := []adminRoom{}
+	for _, rm := range a.allRooms() {
+		info := adminRoom{Name: rm.name}
+		if !rm.do(func() { info.Clients = len(rm.clients) }) {
+			continue
+		}
+		result = append(result, info)
+	}
+	respond(w, r, http.StatusOK, result)
+}
+
+func (a *adminAPI) handleClients(w http.ResponseWriter, r *http.Request, name string) {
+	rm := a.room(name)
+	if rm == nil {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	result := []adminClient{}
+	ok := rm.do(func() {
+		for c := range rm.clients {
+			info := adminClient{ID: c.id, UserID: c.userID()}
+			info.Name, _ = c.userData["name"].(string)
+			info.AvatarURL, _ = c.userData["avatar_url"].(string)
+			result = append(result, info)
+		}
+	})
+	if !ok {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	respond(w, r, http.StatusOK, result)
+}
+
+func (a *adminAPI) handleDisconnectClient(w http.ResponseWriter, r *http.Request, name, idStr string) {
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		respondErr(w, r, http.StatusBadRequest, "クライアントのIDが正しくありません")
+		return
+	}
+	rm := a.room(name)
+	if rm == nil {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	found := false
+	rm.do(func() {
+		for c := range rm.clients {
+			if c.id == id {
+				rm.disconnect(c)
+				found = true
+			}
+		}
+	})
+	if !found {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	respond(w, r, http.StatusOK, nil)
+}
+
+func (a *adminAPI) handleDisconnectUser(w http.ResponseWriter, r *http.Request, userID string) {
+	count := 0
+	for _, rm := range a.allRooms() {
+		rm.do(func() {
+			for c := range rm.clients {
+				if c.userID() == userID {
+					rm.disconnect(c)
+					count++
+				}
+			}
+		})
+	}
+	respond(w, r, http.StatusOK, map[string]int{"disconnected": count})
+}
+
+func (a *adminAPI) handleBroadcast(w http.ResponseWriter, r *http.Request, name string) {
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := decodeBody(r, &body); err != nil || body.Message == "" {
+		respondErr(w, r, http.StatusBadRequest, "messageを指定してください")
+		return
+	}
+	rm := a.room(name)
+	if rm == nil {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	msg := systemMessage(rm.name, body.Message)
+	if !rm.do(func() {
+		rm.store(msg)
+		rm.broadcast(msg)
+	}) {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	respond(w, r, http.StatusCreated, map[string]uint64{"id": msg.ID})
+}
+
+// withAdminKeyはAuthorization: Bearer <key> ヘッダーが正しいリクエストだけをhに渡します
+func withAdminKey(key string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		given := strings.TrimPrefix(auth, "Bearer ")
+		if given == auth || subtle.ConstantTimeCompare([]byte(given), []byte(key)) != 1 {
+			metrics.authFailures.inc("admin_key")
+			respondErr(w, r, http.StatusUnauthorized, "invalid admin key")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
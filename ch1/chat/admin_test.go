@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminAPI(t *testing.T) {
+	r := newRoom("main")
+	go r.run()
+	defer r.close(context.Background(), "test finished")
+	other := newRoom("other")
+	go other.run()
+	defer other.close(context.Background(), "test finished")
+	c := &client{id: 7, send: make(chan *message, 4), room: r,
+		userData: map[string]interface{}{"userid": "abc", "name": "mat"}}
+	r.join <- c
+
+	h := withAdminKey("secret", newAdminAPI(r, other))
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	req := httptest.NewRequest("GET", "/admin/rooms", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("admin API should require the admin key, got %d", w.Code)
+	}
+	req.Header.Set("Authorization", "secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("admin API should require the Bearer scheme, got %d", w.Code)
+	}
+
+	if w := do("GET", "/admin/rooms", ""); !strings.Contains(w.Body.String(), `{"name":"main","clients":1},{"name":"other","clients":0}`) {
+		t.Errorf("unexpected rooms: %s", w.Body.String())
+	}
+	if w := do("GET", "/admin/rooms/main/clients", ""); !strings.Contains(w.Body.String(), `"id":7,"userid":"abc","name":"mat"`) {
+		t.Errorf("unexpected clients: %s", w.Body.String())
+	}
+	if w := do("POST", "/admin/rooms/main/broadcast", `{"message":"maintenance"}`); w.Code != http.StatusCreated {
+		t.Errorf("broadcast failed: %d %s", w.Code, w.Body.String())
+	}
+	if msg := <-c.send; msg.Type != messageSystem || msg.Message != "maintenance" {
+		t.Errorf("client should receive the system message, got %+v", msg)
+	}
+	if w := do("DELETE", "/admin/users/abc", ""); !strings.Contains(w.Body.String(), `"disconnected":1`) {
+		t.Errorf("unexpected disconnect result: %s", w.Body.String())
+	}
+	if _, ok := <-c.send; ok {
+		t.Error("disconnecting a user should close the client's send channel")
+	}
+	// 切断済みのクライアントがleaveしても問題ないこと
+	r.leave <- c
+	if w := do("DELETE", "/admin/rooms/other", ""); w.Code != http.StatusNotFound {
+		t.Errorf("rooms cannot be closed through the API, got %d", w.Code)
+	}
+}
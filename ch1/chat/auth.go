@@ -7,9 +7,11 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/stretchr/gomniauth"
 	"github.com/stretchr/objx"
+	"github.com/taitai9847/goblueprints/ch1/trace"
 
 	gomniauthcommon "github.com/stretchr/gomniauth/common"
 )
@@ -32,20 +34,23 @@ type authHandler struct {
 }
 
 func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	_, err := r.Cookie("auth")
-	if err == http.ErrNoCookie {
-		// not authenticated
+	ctx, span := trace.StartSpan(r.Context(), "auth")
+	defer span.Finish()
+	_, err := authData(r)
+	span.SetAttr("authenticated", err == nil)
+	if err != nil {
+		// not authenticated, or the cookie was not issued by us
+		if err == http.ErrNoCookie {
+			metrics.authFailures.inc("no_cookie")
+		} else {
+			metrics.authFailures.inc("bad_cookie")
+		}
 		w.Header().Set("Location", "/login")
 		w.WriteHeader(http.StatusTemporaryRedirect)
 		return
 	}
-	if err != nil {
-		// some other error
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
 	// success - call the next handler
-	h.next.ServeHTTP(w, r)
+	h.next.ServeHTTP(w, r.WithContext(ctx))
 }
 
 func MustAuth(handler http.Handler) http.Handler {
@@ -60,11 +65,13 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	case "login":
 		provider, err := gomniauth.Provider(provider)
 		if err != nil {
-			log.Fatalln("認証プロバイダーの取得に失敗しました:", provider, err)
+			authFailed(w, "provider", "認証プロバイダーの取得に失敗しました:", provider, err)
+			return
 		}
 		loginUrl, err := provider.GetBeginAuthURL(nil, nil)
 		if err != nil {
-			log.Fatalln("GetBeginAuthURLの取得に失敗しました:", provider, err)
+			authFailed(w, "begin_auth", "GetBeginAuthURLの取得に失敗しました:", provider, err)
+			return
 		}
 		w.Header().Set("Location", loginUrl)
 		w.WriteHeader(http.StatusTemporaryRedirect)
@@ -72,39 +79,34 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 
 		provider, err := gomniauth.Provider(provider)
 		if err != nil {
-			log.Fatalln("Error when trying to get provider", provider, "-", err)
+			authFailed(w, "provider", "Error when trying to get provider", provider, "-", err)
+			return
 		}
 
 		creds, err := provider.CompleteAuth(objx.MustFromURLQuery(r.URL.RawQuery))
 		if err != nil {
-			log.Fatalln("Error when trying to complete auth for", provider, "-", err)
+			authFailed(w, "complete_auth", "Error when trying to complete auth for", provider, "-", err)
+			return
 		}
 
 		user, err := provider.GetUser(creds)
 		if err != nil {
-			log.Fatalln("Error when trying to get user from", provider, "-", err)
+			authFailed(w, "get_user", "Error when trying to get user from", provider, "-", err)
+			return
 		}
-		chatUser := &chatUser{User: user}
-
 		m := md5.New()
 		io.WriteString(m, strings.ToLower(user.Email()))
-		chatUser.uniqueID = fmt.Sprintf("%x", m.Sum(nil))
+		uniqueID := fmt.Sprintf("%x", m.Sum(nil))
 
-		avatarURL, err := avatars.GetAvatarURL(chatUser)
+		profile, err := loginUser(uniqueID, user.Name(), user.AvatarURL(), time.Now())
 		if err != nil {
-			log.Fatalln("Error when trying to GetAvatarURL", "-", err)
+			authFailed(w, "user_store", "Error when trying to store user", "-", err)
+			return
+		}
+		if err := setAuthCookie(w, profile); err != nil {
+			authFailed(w, "auth_cookie", "Error when trying to set auth cookie", "-", err)
+			return
 		}
-
-		authCookieValue := objx.New(map[string]interface{}{
-			"userid":     chatUser.uniqueID,
-			"name":       user.Name(),
-			"avatar_url": avatarURL,
-		}).MustBase64()
-
-		http.SetCookie(w, &http.Cookie{
-			Name:  "auth",
-			Value: authCookieValue,
-			Path:  "/"})
 
 		w.Header().Set("Location", "/chat")
 		w.WriteHeader(http.StatusTemporaryRedirect)
@@ -113,3 +115,21 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "Auth action %s not supported", action)
 	}
 }
+
+// authFailedは認証の失敗を記録し、サーバーを止めずにエラーを返します
+func authFailed(w http.ResponseWriter, reason string, args ...interface{}) {
+	metrics.authFailures.inc(reason)
+	log.Println(args...)
+	http.Error(w, "認証に失敗しました", http.StatusInternalServerError)
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   "auth",
+		Value:  "",
+		Path:   "/",
+		MaxAge: -1,
+	})
+	w.Header().Set("Location", "/chat")
+	w.WriteHeader(http.StatusSeeOther)
+}
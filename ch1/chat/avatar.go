@@ -9,6 +9,9 @@ import (
 
 var ErrNoAvatarURL = errors.New("chat: アバターのURLが取得できません")
 
+// avatarDirはアップロードされたアバター画像を保存するディレクトリです
+var avatarDir = "avatars"
+
 type Avatar interface {
 	GetAvatarURL(ChatUser) (string, error)
 }
@@ -29,7 +32,7 @@ type FileSystemAvatar struct{}
 var UseFileSystemAvatar FileSystemAvatar
 
 func (FileSystemAvatar) GetAvatarURL(u ChatUser) (string, error) {
-	files, err := ioutil.ReadDir("avatars")
+	files, err := ioutil.ReadDir(avatarDir)
 	if err != nil {
 		return "", ErrNoAvatarURL
 	}
@@ -1,44 +1,86 @@
 package main
 
 import (
+	"sync/atomic"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/taitai9847/goblueprints/ch1/trace"
 )
 
 type client struct {
-	// クライアントのためのwebsocket
-	socket *websocket.Conn
+	// idは管理APIでクライアントを指定するための番号
+	id uint64
+	// connはクライアントとの接続 (websocketまたはSSE)
+	conn transport
 	// sendはメッセージが送られるチャネルです
 	send chan *message
 	// roomはこのクライアントが参加しているroom
 	room *room
 	// userDataはユーザーに関する情報
 	userData map[string]interface{}
+	// spanはこのクライアントの接続全体を表すSpan
+	span *trace.Span
+	// sentはこのクライアントから送られたメッセージの数
+	sent int
+	// sinceは再接続したクライアントが最後に受け取ったメッセージのID
+	since uint64
+	// threadsは購読しているスレッドで、roomのrunの中だけで使います
+	threads map[uint64]bool
 }
 
 func (c *client) read() {
-	defer c.socket.Close()
+	defer c.conn.Close(false)
 	for {
-		var msg *message
-		err := c.socket.ReadJSON(&msg)
+		msg, err := c.conn.ReadMessage()
 		if err != nil {
 			return
 		}
+		switch msg.Type {
+		case messageAck:
+			c.room.markRead(c, msg.ID)
+			continue
+		case messageSubscribe, messageUnsubscribe:
+			c.room.subscribe(c, c.room.threadRoot(msg.ParentID), msg.Type == messageSubscribe)
+			continue
+		}
+		if msg.ParentID != 0 {
+			// 返信したスレッドは自動的に購読します
+			msg.ParentID = c.room.threadRoot(msg.ParentID)
+			c.room.subscribe(c, msg.ParentID, true)
+		}
+		msg.ID = 0
+		msg.Type = ""
 		msg.When = time.Now()
 		msg.Name = c.userData["name"].(string)
 		if avatarURL, ok := c.userData["avatar_url"]; ok {
 			msg.AvatarURL = avatarURL.(string)
 		}
-		c.room.forward <- msg
+		c.sent++
+		c.span.SetAttr("messages", c.sent)
+		select {
+		case c.room.forward <- msg:
+		case <-c.room.done:
+			return
+		}
 	}
 }
 
+var lastClientID uint64
+
+func nextClientID() uint64 {
+	return atomic.AddUint64(&lastClientID, 1)
+}
+
+func (c *client) userID() string {
+	id, _ := c.userData["userid"].(string)
+	return id
+}
+
 func (c *client) write() {
 	for msg := range c.send {
-		if err := c.socket.WriteJSON(msg); err != nil {
+		if err := c.conn.WriteMessage(msg); err != nil {
 			break
 		}
 	}
-	c.socket.Close()
+	c.conn.Close(c.room.closed())
 }
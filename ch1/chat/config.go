@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"github.com/stretchr/gomniauth/providers/facebook"
+	"github.com/stretchr/gomniauth/providers/github"
+	"github.com/stretchr/gomniauth/providers/google"
+	"gopkg.in/yaml.v2"
+
+	gomniauthcommon "github.com/stretchr/gomniauth/common"
+)
+
+// config はチャットサーバーの設定です。
+// 優先順位は 既定値 < 設定ファイル < 環境変数 < コマンドラインフラグ です。
+type config struct {
+	Addr            string   `json:"addr" yaml:"addr" toml:"addr"`
+	BaseURL         string   `json:"base_url" yaml:"base_url" toml:"base_url"`
+	CertFile        string   `json:"cert_file" yaml:"cert_file" toml:"cert_file"`
+	KeyFile         string   `json:"key_file" yaml:"key_file" toml:"key_file"`
+	ShutdownTimeout duration `json:"shutdown_timeout" yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	SpanLog         string   `json:"span_log" yaml:"span_log" toml:"span_log"`
+	// Dev がtrueのときはPaths.Templatesのテンプレートを変更のたびに読み直します。
+	// falseのときはバイナリに埋め込まれたテンプレートを使います
+	Dev         bool   `json:"dev" yaml:"dev" toml:"dev"`
+	SecurityKey string `json:"security_key" yaml:"security_key" toml:"security_key"`
+	// AdminKey は管理API (/admin/) のBearerトークンです。空の場合は管理APIを公開しません
+	AdminKey  string         `json:"admin_key" yaml:"admin_key" toml:"admin_key"`
+	Providers []providerConf `json:"providers" yaml:"providers" toml:"providers"`
+	Buffers   bufferConf     `json:"buffers" yaml:"buffers" toml:"buffers"`
+	Paths     pathConf       `json:"paths" yaml:"paths" toml:"paths"`
+	// Avatars はアバターURLを探す順番です (filesystem, auth, gravatar)
+	Avatars []string `json:"avatars" yaml:"avatars" toml:"avatars"`
+	// AllowedOrigins は同じホスト以外でwebsocketの接続を許可するオリジンです
+	AllowedOrigins []string `json:"allowed_origins" yaml:"allowed_origins" toml:"allowed_origins"`
+}
+
+type providerConf struct {
+	Name         string `json:"name" yaml:"name" toml:"name"`
+	ClientID     string `json:"client_id" yaml:"client_id" toml:"client_id"`
+	ClientSecret string `json:"client_secret" yaml:"client_secret" toml:"client_secret"`
+	// CallbackURL を省略した場合は BaseURL + "/auth/callback/" + Name になります
+	CallbackURL string `json:"callback_url" yaml:"callback_url" toml:"callback_url"`
+}
+
+type bufferConf struct {
+	Socket  int `json:"socket" yaml:"socket" toml:"socket"`
+	Message int `json:"message" yaml:"message" toml:"message"`
+	// History はroomごとにメモリに残す履歴の件数です。古いものはファイルにだけ残ります
+	History int `json:"history" yaml:"history" toml:"history"`
+}
+
+type pathConf struct {
+	Templates string `json:"templates" yaml:"templates" toml:"templates"`
+	Avatars   string `json:"avatars" yaml:"avatars" toml:"avatars"`
+	// History はメッセージ履歴を保存するファイルです。空の場合はメモリにだけ保持します
+	History string `json:"history" yaml:"history" toml:"history"`
+	// Receipts はユーザーごとの既読位置を保存するファイルです。空の場合はメモリにだけ保持します
+	Receipts string `json:"receipts" yaml:"receipts" toml:"receipts"`
+	// Users はユーザーのプロフィールを保存するファイルです。空の場合はメモリにだけ保持します
+	Users string `json:"users" yaml:"users" toml:"users"`
+}
+
+// duration は設定ファイル中の "10s" のような文字列を読み込むためのtime.Durationです
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.Set(s)
+}
+
+func (d *duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.Set(s)
+}
+
+func (d *duration) UnmarshalText(b []byte) error {
+	return d.Set(string(b))
+}
+
+func (d *duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func defaultConfig() *config {
+	return &config{
+		Addr:            ":8080",
+		BaseURL:         "http://localhost:8080",
+		ShutdownTimeout: duration{10 * time.Second},
+		Buffers: bufferConf{
+			Socket:  socketBufferSize,
+			Message: messageBufferSize,
+			History: historyBufferSize,
+		},
+		Paths: pathConf{
+			Templates: "templates",
+			Avatars:   "avatars",
+		},
+		Avatars: []string{"filesystem", "auth", "gravatar"},
+	}
+}
+
+// loadConfig はargsとgetenvから設定を組み立てます。
+// -config で指定されたファイルは拡張子 (.json, .yaml, .yml, .toml) で形式を判断します。
+// -env の既定値は空で、.envを読み込むのは明示的に指定されたときだけです。
+func loadConfig(args []string, getenv func(string) string) (*config, error) {
+	fs := flag.NewFlagSet("chat", flag.ContinueOnError)
+	var (
+		file            = fs.String("config", "", "Path of a JSON, YAML or TOML config file.")
+		envFile         = fs.String("env", "", "Path of a .env file loaded into the environment, relative to the working directory.")
+		addr            = fs.String("addr", "", "The addr of the application.")
+		baseURL         = fs.String("baseurl", "", "Public URL of the application, used for OAuth callbacks.")
+		spanLog         = fs.String("spanlog", "", "Path of the JSON lines file spans are written to.")
+		certFile        = fs.String("cert", "", "TLS certificate file. Serves HTTPS when set together with -key.")
+		keyFile         = fs.String("key", "", "TLS private key file.")
+		shutdownTimeout = fs.Duration("shutdown-timeout", 0, "How long to wait for requests to finish on shutdown.")
+		avatarOrder     = fs.String("avatars", "", "Comma separated avatar lookup order.")
+		dev             = fs.Bool("dev", false, "Reload templates from disk when they change.")
+		origins         = fs.String("origins", "", "Comma separated origins allowed to open websockets.")
+	)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *envFile != "" {
+		if err := godotenv.Load(*envFile); err != nil {
+			log.Println(".envを読み込み出来ませんでした:", err)
+		}
+	}
+
+	c := defaultConfig()
+	if *file != "" {
+		if err := c.readFile(*file); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.applyEnv(getenv); err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			c.Addr = *addr
+		case "baseurl":
+			c.BaseURL = *baseURL
+		case "spanlog":
+			c.SpanLog = *spanLog
+		case "cert":
+			c.CertFile = *certFile
+		case "key":
+			c.KeyFile = *keyFile
+		case "shutdown-timeout":
+			c.ShutdownTimeout.Duration = *shutdownTimeout
+		case "avatars":
+			c.Avatars = splitList(*avatarOrder)
+		case "dev":
+			c.Dev = *dev
+		case "origins":
+			c.AllowedOrigins = splitList(*origins)
+		}
+	})
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *config) readFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %s: %v", path, err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(b, c)
+	case ".toml":
+		err = readTOML(b, c)
+	default:
+		return fmt.Errorf("config: %s: unsupported file format", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config: %s: %v", path, err)
+	}
+	return nil
+}
+
+// readTOML はYAMLやJSONと同じく、知らないキーがあればエラーにします
+func readTOML(b []byte, c *config) error {
+	md, err := toml.Decode(string(b), c)
+	if err != nil {
+		return err
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, k := range undecoded {
+			keys[i] = k.String()
+		}
+		return fmt.Errorf("unknown keys %s", strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+// applyEnv はCHAT_で始まる環境変数で設定を上書きします。
+// 以前からのAPP_SECURITY_KEY, CLIENT_ID, CLIENT_SECRETはgoogleの設定として扱います。
+func (c *config) applyEnv(getenv func(string) string) error {
+	setString := func(key string, dst *string) {
+		if v := getenv(key); v != "" {
+			*dst = v
+		}
+	}
+	setInt := func(key string, dst *int) error {
+		v := getenv(key)
+		if v == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("config: %s: %v", key, err)
+		}
+		*dst = n
+		return nil
+	}
+
+	setString("APP_SECURITY_KEY", &c.SecurityKey)
+	setString("CHAT_SECURITY_KEY", &c.SecurityKey)
+	setString("CHAT_ADMIN_KEY", &c.AdminKey)
+	setString("CHAT_ADDR", &c.Addr)
+	setString("CHAT_BASE_URL", &c.BaseURL)
+	setString("CHAT_CERT_FILE", &c.CertFile)
+	setString("CHAT_KEY_FILE", &c.KeyFile)
+	setString("CHAT_SPAN_LOG", &c.SpanLog)
+	setString("CHAT_TEMPLATES", &c.Paths.Templates)
+	if v := getenv("CHAT_DEV"); v != "" {
+		dev, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("config: CHAT_DEV: %v", err)
+		}
+		c.Dev = dev
+	}
+	setString("CHAT_AVATARS_DIR", &c.Paths.Avatars)
+	setString("CHAT_HISTORY", &c.Paths.History)
+	setString("CHAT_RECEIPTS", &c.Paths.Receipts)
+	setString("CHAT_USERS", &c.Paths.Users)
+	if v := getenv("CHAT_SHUTDOWN_TIMEOUT"); v != "" {
+		if err := c.ShutdownTimeout.Set(v); err != nil {
+			return fmt.Errorf("config: CHAT_SHUTDOWN_TIMEOUT: %v", err)
+		}
+	}
+	if err := setInt("CHAT_SOCKET_BUFFER_SIZE", &c.Buffers.Socket); err != nil {
+		return err
+	}
+	if err := setInt("CHAT_MESSAGE_BUFFER_SIZE", &c.Buffers.Message); err != nil {
+		return err
+	}
+	if err := setInt("CHAT_HISTORY_BUFFER_SIZE", &c.Buffers.History); err != nil {
+		return err
+	}
+	if v := getenv("CHAT_AVATARS"); v != "" {
+		c.Avatars = splitList(v)
+	}
+	if v := getenv("CHAT_ALLOWED_ORIGINS"); v != "" {
+		c.AllowedOrigins = splitList(v)
+	}
+
+	if id, secret := getenv("CLIENT_ID"), getenv("CLIENT_SECRET"); id != "" || secret != "" {
+		p := c.provider("google")
+		setString("CLIENT_ID", &p.ClientID)
+		setString("CLIENT_SECRET", &p.ClientSecret)
+	}
+	for name := range providerConstructors {
+		prefix := "CHAT_" + strings.ToUpper(name) + "_"
+		id, secret := getenv(prefix+"CLIENT_ID"), getenv(prefix+"CLIENT_SECRET")
+		if id == "" && secret == "" {
+			continue
+		}
+		p := c.provider(name)
+		setString(prefix+"CLIENT_ID", &p.ClientID)
+		setString(prefix+"CLIENT_SECRET", &p.ClientSecret)
+		setString(prefix+"CALLBACK_URL", &p.CallbackURL)
+	}
+	return nil
+}
+
+// provider は名前がnameのプロバイダー設定を返します。なければ追加します
+func (c *config) provider(name string) *providerConf {
+	for i := range c.Providers {
+		if c.Providers[i].Name == name {
+			return &c.Providers[i]
+		}
+	}
+	c.Providers = append(c.Providers, providerConf{Name: name})
+	return &c.Providers[len(c.Providers)-1]
+}
+
+// configError は設定の検証で見つかったすべての問題をまとめたエラーです
+type configError []string
+
+func (e configError) Error() string {
+	return "config: " + strings.Join(e, "; ")
+}
+
+func (c *config) validate() error {
+	var errs configError
+	if c.Addr == "" {
+		errs = append(errs, "addr must not be empty")
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		errs = append(errs, "cert_file and key_file must be set together")
+	}
+	if c.ShutdownTimeout.Duration < 0 {
+		errs = append(errs, "shutdown_timeout must not be negative")
+	}
+	if c.Buffers.Socket <= 0 {
+		errs = append(errs, "buffers.socket must be positive")
+	}
+	if c.Buffers.Message <= 0 {
+		errs = append(errs, "buffers.message must be positive")
+	}
+	if c.Buffers.History <= 0 {
+		errs = append(errs, "buffers.history must be positive")
+	}
+	if c.Dev && c.Paths.Templates == "" {
+		errs = append(errs, "paths.templates must be set in dev mode")
+	}
+	if c.Paths.Avatars == "" {
+		errs = append(errs, "paths.avatars must not be empty")
+	}
+	if len(c.Avatars) == 0 {
+		errs = append(errs, "avatars must list at least one avatar source")
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("allowed origin %q must look like scheme://host", origin))
+		}
+	}
+	for _, name := range c.Avatars {
+		if _, ok := avatarSources[name]; !ok {
+			errs = append(errs, fmt.Sprintf("unknown avatar source %q", name))
+		}
+	}
+	if len(c.Providers) == 0 {
+		errs = append(errs, "at least one auth provider must be configured")
+	}
+	seen := map[string]bool{}
+	for _, p := range c.Providers {
+		if _, ok := providerConstructors[p.Name]; !ok {
+			errs = append(errs, fmt.Sprintf("unknown auth provider %q", p.Name))
+			continue
+		}
+		if seen[p.Name] {
+			errs = append(errs, fmt.Sprintf("auth provider %q configured twice", p.Name))
+		}
+		seen[p.Name] = true
+		if p.ClientID == "" || p.ClientSecret == "" {
+			errs = append(errs, fmt.Sprintf("auth provider %q needs client_id and client_secret", p.Name))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+var avatarSources = map[string]Avatar{
+	"filesystem": UseFileSystemAvatar,
+	"auth":       UseAuthAvatar,
+	"gravatar":   UseGravatar,
+}
+
+// avatarChain は設定された順番でアバターを探すTryAvatarsを返します
+func (c *config) avatarChain() TryAvatars {
+	var chain TryAvatars
+	for _, name := range c.Avatars {
+		chain = append(chain, avatarSources[name])
+	}
+	return chain
+}
+
+var providerConstructors = map[string]func(clientID, clientSecret, callbackURL string) gomniauthcommon.Provider{
+	"google": func(id, secret, callback string) gomniauthcommon.Provider {
+		return google.New(id, secret, callback)
+	},
+	"github": func(id, secret, callback string) gomniauthcommon.Provider {
+		return github.New(id, secret, callback)
+	},
+	"facebook": func(id, secret, callback string) gomniauthcommon.Provider {
+		return facebook.New(id, secret, callback)
+	},
+}
+
+// authProviders は設定されたプロバイダーを作成します
+func (c *config) authProviders() []gomniauthcommon.Provider {
+	var providers []gomniauthcommon.Provider
+	for _, p := range c.Providers {
+		callback := p.CallbackURL
+		if callback == "" {
+			callback = strings.TrimSuffix(c.BaseURL, "/") + "/auth/callback/" + p.Name
+		}
+		providers = append(providers, providerConstructors[p.Name](p.ClientID, p.ClientSecret, callback))
+	}
+	return providers
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func envMap(m map[string]string) func(string) string {
+	return func(key string) string { return m[key] }
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "chat.yaml")
+	ioutil.WriteFile(file, []byte(`
+addr: ":9000"
+shutdown_timeout: 3s
+buffers:
+  message: 16
+providers:
+  - name: github
+    client_id: file-id
+    client_secret: file-secret
+avatars: [gravatar]
+`), 0644)
+	env := envMap(map[string]string{
+		"CHAT_ADDR":     ":9001",
+		"CLIENT_ID":     "google-id",
+		"CLIENT_SECRET": "google-secret",
+		"CHAT_BASE_URL": "https://chat.example.com",
+		"CHAT_AVATARS":  "auth, gravatar",
+		"CHAT_SPAN_LOG": "spans.log",
+	})
+	c, err := loadConfig([]string{"-env", "", "-config", file, "-addr", ":9002"}, env)
+	if err != nil {
+		t.Fatalf("loadConfig failed: %s", err)
+	}
+	if c.Addr != ":9002" {
+		t.Errorf("flags should win over env and file, got addr %s", c.Addr)
+	}
+	if c.ShutdownTimeout.Duration != 3*time.Second || c.Buffers.Message != 16 || c.Buffers.Socket != socketBufferSize {
+		t.Errorf("file values should override defaults: %+v", c)
+	}
+	if strings.Join(c.Avatars, ",") != "auth,gravatar" {
+		t.Errorf("env should override file avatars, got %v", c.Avatars)
+	}
+	if len(c.Providers) != 2 {
+		t.Fatalf("expected github and google providers, got %+v", c.Providers)
+	}
+	if got := len(c.authProviders()); got != 2 {
+		t.Errorf("authProviders should build 2 providers, got %d", got)
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	env := envMap(map[string]string{
+		"CHAT_MESSAGE_BUFFER_SIZE": "0",
+		"CHAT_AVATARS":             "filesystem,unknown",
+		"CHAT_CERT_FILE":           "cert.pem",
+	})
+	_, err := loadConfig([]string{"-env", ""}, env)
+	cerr, ok := err.(configError)
+	if !ok {
+		t.Fatalf("loadConfig should return a configError, got %v", err)
+	}
+	for _, want := range []string{
+		"buffers.message",
+		`unknown avatar source "unknown"`,
+		"cert_file and key_file",
+		"at least one auth provider",
+	} {
+		if !strings.Contains(cerr.Error(), want) {
+			t.Errorf("validation error should mention %q: %s", want, cerr)
+		}
+	}
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "chat.toml")
+	ioutil.WriteFile(file, []byte(`
+addr = ":9000"
+shutdown_timeout = "3s"
+
+[[providers]]
+name = "github"
+client_id = "file-id"
+client_secret = "file-secret"
+`), 0644)
+	c, err := loadConfig([]string{"-config", file}, envMap(nil))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %s", err)
+	}
+	if c.Addr != ":9000" || c.ShutdownTimeout.Duration != 3*time.Second || len(c.Providers) != 1 {
+		t.Errorf("TOML values were not loaded: %+v", c)
+	}
+}
+
+func TestLoadConfigUnknownKeys(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"chat.json": `{"addr": ":9000", "adress": ":9001"}`,
+		"chat.yaml": "addr: \":9000\"\nadress: \":9001\"\n",
+		"chat.toml": "addr = \":9000\"\nadress = \":9001\"\n",
+	}
+	for name, body := range files {
+		file := filepath.Join(dir, name)
+		ioutil.WriteFile(file, []byte(body), 0644)
+		_, err := loadConfig([]string{"-config", file}, envMap(nil))
+		if err == nil || !strings.Contains(err.Error(), "adress") {
+			t.Errorf("%s: unknown keys should be rejected, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	csrfCookieName = "csrf"
+	csrfFieldName  = "csrf_token"
+)
+
+// csrfTokenはリクエストのCSRFトークンを返します。
+// まだ発行されていなければ新しく作ってクッキーに保存します。
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookieName); err == nil && c.Value != "" {
+		return c.Value
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// validCSRFはフォームで送られたトークンがクッキーのトークンと一致するかを調べます
+func validCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookieName)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	token := r.FormValue(csrfFieldName)
+	if token == "" {
+		token = r.Header.Get("X-CSRF-Token")
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(token)) == 1
+}
+
+// withCSRFはPOSTのみを受け付け、CSRFトークンが正しいときだけfnを呼び出します
+func withCSRF(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if !validCSRF(r) {
+			http.Error(w, "CSRFトークンが正しくありません", http.StatusForbidden)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// checkOriginはwebsocketの接続元を検証する関数を返します。
+// 同じホストからの接続と、allowedに含まれるオリジンからの接続だけを許可します。
+// allowedに "*" を含めるとすべてのオリジンを許可します。
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// ブラウザ以外のクライアントはOriginを送りません
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		if strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		for _, a := range allowed {
+			if a == "*" || strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWithCSRF(t *testing.T) {
+	called := false
+	h := withCSRF(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+	form := url.Values{csrfFieldName: {"token"}}
+	req := httptest.NewRequest("POST", "/logout", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h(w, req)
+	if w.Code != http.StatusForbidden || called {
+		t.Errorf("withCSRF should reject requests without a csrf cookie, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/logout", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "token"})
+	w = httptest.NewRecorder()
+	h(w, req)
+	if !called {
+		t.Errorf("withCSRF should accept a matching token, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/logout", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("withCSRF should only accept POST, got %d", w.Code)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	check := checkOrigin([]string{"https://chat.example.com"})
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"http://localhost:8080", true},
+		{"https://chat.example.com", true},
+		{"https://evil.example.com", false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "http://localhost:8080/room", nil)
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		if got := check(req); got != test.ok {
+			t.Errorf("checkOrigin(%q) = %v, want %v", test.origin, got, test.ok)
+		}
+	}
+}
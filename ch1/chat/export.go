@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const transcriptTimeFormat = "2006-01-02 15:04:05"
+
+// historyExporter はメッセージの一覧を特定の形式で書き出します
+type historyExporter struct {
+	contentType string
+	ext         string
+	write       func(w io.Writer, msgs []*message) error
+}
+
+var historyExporters = map[string]historyExporter{
+	"json": {"application/json; charset=utf-8", "json", exportJSON},
+	"csv":  {"text/csv; charset=utf-8", "csv", exportCSV},
+	"txt":  {"text/plain; charset=utf-8", "txt", exportTranscript},
+}
+
+type exportedMessage struct {
+	Room    string    `json:"room"`
+	Name    string    `json:"name"`
+	Message string    `json:"message"`
+	When    time.Time `json:"when"`
+}
+
+func exportMessages(msgs []*message) []exportedMessage {
+	out := make([]exportedMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		out = append(out, exportedMessage{Room: msg.Room, Name: msg.Name, Message: msg.Message, When: msg.When})
+	}
+	return out
+}
+
+func exportJSON(w io.Writer, msgs []*message) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(exportMessages(msgs))
+}
+
+func exportCSV(w io.Writer, msgs []*message) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"room", "when", "name", "message"})
+	for _, msg := range msgs {
+		cw.Write([]string{msg.Room, msg.When.Format(time.RFC3339), msg.Name, msg.Message})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func exportTranscript(w io.Writer, msgs []*message) error {
+	for _, msg := range msgs {
+		if _, err := fmt.Fprintf(w, "[%s] %s: %s\n", msg.When.Format(transcriptTimeFormat), msg.Name, msg.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// historySearchHandler は GET /api/history/search?q=&user=&room=&from=&to=&limit= で履歴を検索します。
+// from, toはRFC3339形式です。
+func historySearchHandler(h *historyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			respondHTTPErr(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		q, err := parseHistoryQuery(r)
+		if err != nil {
+			respondErr(w, r, http.StatusBadRequest, err)
+			return
+		}
+		respond(w, r, http.StatusOK, exportMessages(h.Search(q)))
+	}
+}
+
+// historyExportHandler は GET /api/history/export?room=&format=json|csv|txt でroomの履歴を書き出します
+func historyExportHandler(h *historyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			respondHTTPErr(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		room := r.URL.Query().Get("room")
+		if room == "" {
+			respondErr(w, r, http.StatusBadRequest, "roomを指定してください")
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		exporter, ok := historyExporters[format]
+		if !ok {
+			respondErr(w, r, http.StatusBadRequest, "対応していない形式です: ", format)
+			return
+		}
+		w.Header().Set("Content-Type", exporter.contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", room+"."+exporter.ext))
+		if err := exporter.write(w, h.Messages(room)); err != nil {
+			log.Println("履歴の書き出しに失敗しました:", err)
+		}
+	}
+}
+
+func parseHistoryQuery(r *http.Request) (historyQuery, error) {
+	v := r.URL.Query()
+	q := historyQuery{
+		Text: v.Get("q"),
+		User: v.Get("user"),
+		Room: v.Get("room"),
+	}
+	var err error
+	if s := v.Get("from"); s != "" {
+		if q.From, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("fromの形式が正しくありません: %v", err)
+		}
+	}
+	if s := v.Get("to"); s != "" {
+		if q.To, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("toの形式が正しくありません: %v", err)
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 0 {
+			return q, fmt.Errorf("limitの形式が正しくありません: %s", s)
+		}
+	}
+	return q, nil
+}
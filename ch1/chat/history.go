@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyStore はroomごとのメッセージ履歴を保持します。
+// ファイルを指定した場合は1行1件のJSONとして追記し、起動時に読み込みます。
+// メモリにはroomごとに新しいほうからlimit件だけを残します (0以下の場合は制限しません)。
+type historyStore struct {
+	mu    sync.RWMutex
+	limit int
+	rooms map[string][]*message
+	// byIDはIDからメッセージを引くための索引
+	byID map[uint64]*message
+	// repliesはスレッドの最初のメッセージのIDごとの返信
+	replies map[uint64][]*message
+	// lastIDは最後に割り当てたメッセージのID
+	lastID uint64
+	out    io.WriteCloser
+	enc    *json.Encoder
+}
+
+func newHistoryStore(limit int) *historyStore {
+	return &historyStore{
+		limit:   limit,
+		rooms:   map[string][]*message{},
+		byID:    map[uint64]*message{},
+		replies: map[uint64][]*message{},
+	}
+}
+
+func (h *historyStore) add(msg *message) {
+	h.rooms[msg.Room] = append(h.rooms[msg.Room], msg)
+	h.byID[msg.ID] = msg
+	if msg.ParentID != 0 {
+		h.replies[msg.ParentID] = append(h.replies[msg.ParentID], msg)
+	}
+	if msg.ID > h.lastID {
+		h.lastID = msg.ID
+	}
+	if msgs := h.rooms[msg.Room]; h.limit > 0 && len(msgs) > h.limit {
+		for _, old := range msgs[:len(msgs)-h.limit] {
+			h.forget(old)
+		}
+		h.rooms[msg.Room] = msgs[len(msgs)-h.limit:]
+	}
+}
+
+// forgetはroomから押し出されたmsgを索引から外します
+func (h *historyStore) forget(msg *message) {
+	delete(h.byID, msg.ID)
+	delete(h.replies, msg.ID)
+	if replies := h.replies[msg.ParentID]; len(replies) > 0 && replies[0] == msg {
+		if len(replies) == 1 {
+			delete(h.replies, msg.ParentID)
+		} else {
+			h.replies[msg.ParentID] = replies[1:]
+		}
+	}
+}
+
+// openHistory はpathの履歴を読み込み、以降のメッセージを追記するhistoryStoreを返します。
+// 読めない行は飛ばし、書きかけのまま終わっている最後の行は切り詰めてから追記を始めます。
+func openHistory(path string, limit int) (*historyStore, error) {
+	h := newHistoryStore(limit)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		r       = bufio.NewReader(f)
+		offset  int64
+		skipped int
+	)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				// 改行で終わっていない行の後ろに追記すると次の行まで壊れてしまいます
+				if err := f.Truncate(offset); err != nil {
+					f.Close()
+					return nil, err
+				}
+				log.Printf("履歴の最後の書きかけの行を切り詰めました: %s", path)
+			}
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		offset += int64(len(line))
+		var msg message
+		if err := json.Unmarshal(line, &msg); err != nil || msg.ID == 0 {
+			skipped++
+			continue
+		}
+		h.add(&msg)
+	}
+	if skipped > 0 {
+		log.Printf("履歴の読めない行を%d行飛ばしました: %s", skipped, path)
+	}
+	h.out = f
+	h.enc = json.NewEncoder(f)
+	return h, nil
+}
+
+// Append はmsgに新しいIDを割り当てて履歴に追加します
+func (h *historyStore) Append(msg *message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	msg.ID = h.lastID + 1
+	h.add(msg)
+	if h.enc != nil {
+		return h.enc.Encode(msg)
+	}
+	return nil
+}
+
+// LastID は最後に割り当てたメッセージのIDを返します
+func (h *historyStore) LastID() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.lastID
+}
+
+// Messages はroomの履歴を古い順に返します
+func (h *historyStore) Messages(room string) []*message {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	msgs := make([]*message, len(h.rooms[room]))
+	copy(msgs, h.rooms[room])
+	return msgs
+}
+
+// Since はroomの履歴のうちIDがidより大きいものを古い順に返します
+func (h *historyStore) Since(room string, id uint64) []*message {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	msgs := h.rooms[room]
+	i := sort.Search(len(msgs), func(i int) bool { return msgs[i].ID > id })
+	since := make([]*message, len(msgs)-i)
+	copy(since, msgs[i:])
+	return since
+}
+
+// Get はIDのメッセージを返します
+func (h *historyStore) Get(id uint64) (*message, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	msg, ok := h.byID[id]
+	return msg, ok
+}
+
+// Thread はidのメッセージで始まるスレッドの返信を古い順に返します
+func (h *historyStore) Thread(id uint64) []*message {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	replies := make([]*message, len(h.replies[id]))
+	copy(replies, h.replies[id])
+	return replies
+}
+
+// Replies はidのメッセージで始まるスレッドの返信の数を返します
+func (h *historyStore) Replies(id uint64) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.replies[id])
+}
+
+func (h *historyStore) Close() error {
+	if h.out == nil {
+		return nil
+	}
+	return h.out.Close()
+}
+
+// historyQuery は履歴の検索条件です。空の項目は条件に含めません
+type historyQuery struct {
+	// Text に含まれる単語がすべて本文に含まれるメッセージを探します (大文字小文字は区別しません)
+	Text  string
+	User  string
+	Room  string
+	From  time.Time
+	To    time.Time
+	Limit int
+}
+
+func (q historyQuery) match(msg *message) bool {
+	if q.Room != "" && msg.Room != q.Room {
+		return false
+	}
+	if q.User != "" && !strings.EqualFold(msg.Name, q.User) {
+		return false
+	}
+	if !q.From.IsZero() && msg.When.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && msg.When.After(q.To) {
+		return false
+	}
+	body := strings.ToLower(msg.Message)
+	for _, term := range strings.Fields(strings.ToLower(q.Text)) {
+		if !strings.Contains(body, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// Search は条件に合うメッセージを古い順に返します。
+// Limitを超える場合は新しいほうからLimit件を返します。
+func (h *historyStore) Search(q historyQuery) []*message {
+	h.mu.RLock()
+	var found []*message
+	for room, msgs := range h.rooms {
+		if q.Room != "" && room != q.Room {
+			continue
+		}
+		for _, msg := range msgs {
+			if q.match(msg) {
+				found = append(found, msg)
+			}
+		}
+	}
+	h.mu.RUnlock()
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].When.Before(found[j].When)
+	})
+	if q.Limit > 0 && len(found) > q.Limit {
+		found = found[len(found)-q.Limit:]
+	}
+	return found
+}
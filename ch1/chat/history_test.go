@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testHistory(t *testing.T, h *historyStore) time.Time {
+	base := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	for i, m := range []struct{ room, name, text string }{
+		{"main", "mat", "Hello Go world"},
+		{"main", "tyler", "hello there"},
+		{"other", "mat", "hello from other"},
+		{"main", "mat", "goodbye"},
+	} {
+		if err := h.Append(&message{Room: m.room, Name: m.name, Message: m.text, When: base.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return base
+}
+
+func TestHistorySearch(t *testing.T) {
+	h := newHistoryStore(0)
+	base := testHistory(t, h)
+
+	tests := []struct {
+		q    historyQuery
+		want int
+	}{
+		{historyQuery{Text: "hello"}, 3},
+		{historyQuery{Text: "HELLO go"}, 1},
+		{historyQuery{Text: "hello", Room: "main"}, 2},
+		{historyQuery{User: "mat"}, 3},
+		{historyQuery{From: base.Add(time.Minute), To: base.Add(2 * time.Minute)}, 2},
+		{historyQuery{Limit: 1}, 1},
+	}
+	for _, test := range tests {
+		if got := h.Search(test.q); len(got) != test.want {
+			t.Errorf("Search(%+v) returned %d messages, want %d", test.q, len(got), test.want)
+		}
+	}
+	if got := h.Search(historyQuery{Limit: 1}); got[0].Message != "goodbye" {
+		t.Errorf("Search with Limit should keep the newest messages, got %q", got[0].Message)
+	}
+}
+
+func TestHistoryExport(t *testing.T) {
+	h := newHistoryStore(0)
+	testHistory(t, h)
+	msgs := h.Messages("main")
+
+	var buf bytes.Buffer
+	exportTranscript(&buf, msgs)
+	if !strings.HasPrefix(buf.String(), "[2021-10-01 12:00:00] mat: Hello Go world\n") {
+		t.Errorf("unexpected transcript:\n%s", buf.String())
+	}
+	buf.Reset()
+	exportCSV(&buf, msgs)
+	if lines := strings.Count(buf.String(), "\n"); lines != 4 {
+		t.Errorf("CSV should have a header and 3 rows, got %d lines", lines)
+	}
+	buf.Reset()
+	exportJSON(&buf, msgs)
+	if !strings.Contains(buf.String(), `"name": "tyler"`) {
+		t.Errorf("unexpected JSON export:\n%s", buf.String())
+	}
+}
+
+func TestOpenHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	h, err := openHistory(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testHistory(t, h)
+	h.Close()
+
+	h, err = openHistory(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if got := len(h.Messages("main")); got != 3 {
+		t.Errorf("openHistory should reload stored messages, got %d", got)
+	}
+}
+
+func TestHistoryThread(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	h, err := openHistory(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testHistory(t, h)
+	h.Append(&message{Room: "main", Name: "tyler", Message: "reply", ParentID: 1})
+	h.Append(&message{Room: "main", Name: "mat", Message: "another reply", ParentID: 1})
+	h.Close()
+
+	h, err = openHistory(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if msg, ok := h.Get(2); !ok || msg.Message != "hello there" {
+		t.Errorf("Get(2) = %+v, %v", msg, ok)
+	}
+	if _, ok := h.Get(100); ok {
+		t.Error("Get should fail for unknown IDs")
+	}
+	replies := h.Thread(1)
+	if len(replies) != 2 || replies[0].Message != "reply" || h.Replies(1) != 2 {
+		t.Errorf("unexpected thread: %+v", replies)
+	}
+	if len(h.Thread(2)) != 0 {
+		t.Error("messages without replies should have an empty thread")
+	}
+}
+
+func TestOpenHistoryBrokenLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	ioutil.WriteFile(path, []byte(`{"id":1,"room":"main","message":"first"}
+not json
+{"id":2,"room":"main","message":"second"}
+{"id":3,"room":"main","mess`), 0644)
+	h, err := openHistory(path, 0)
+	if err != nil {
+		t.Fatalf("openHistory should skip broken lines: %s", err)
+	}
+	if got := len(h.Messages("main")); got != 2 {
+		t.Errorf("openHistory should load the 2 readable messages, got %d", got)
+	}
+	h.Append(&message{Room: "main", Message: "third"})
+	h.Close()
+
+	h, err = openHistory(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	msgs := h.Messages("main")
+	if len(msgs) != 3 || msgs[2].Message != "third" || msgs[2].ID != 3 {
+		t.Errorf("the half-written line should be truncated before appending, got %+v", msgs)
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	h := newHistoryStore(2)
+	h.Append(&message{Room: "main", Message: "root"})
+	h.Append(&message{Room: "main", Message: "reply", ParentID: 1})
+	h.Append(&message{Room: "main", Message: "latest"})
+	h.Append(&message{Room: "other", Message: "other"})
+	msgs := h.Messages("main")
+	if len(msgs) != 2 || msgs[0].Message != "reply" {
+		t.Errorf("history should keep the newest 2 messages per room, got %+v", msgs)
+	}
+	if _, ok := h.Get(1); ok {
+		t.Error("messages pushed out of the room should not be found by ID")
+	}
+	h.Append(&message{Room: "main", Message: "newer"})
+	if h.Replies(1) != 0 {
+		t.Error("replies pushed out of the room should not be counted")
+	}
+	if len(h.Messages("other")) != 1 {
+		t.Error("the limit should apply per room")
+	}
+}
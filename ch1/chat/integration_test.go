@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/objx"
+
+	gomniauthcommon "github.com/stretchr/gomniauth/common"
+)
+
+// このファイルはチャットサーバー全体を起動して確かめるためのテスト用の道具です。
+// newTestServerでサーバーを起動し、loginでユーザーとしてログインし、
+// dialで/roomにwebsocketで接続します。
+
+const (
+	testAdminKey = "test-admin-key"
+	testTimeout  = 2 * time.Second
+)
+
+func init() {
+	providerConstructors["fake"] = func(id, secret, callback string) gomniauthcommon.Provider {
+		return &fakeProvider{callback: callback}
+	}
+	providerTitles["fake"] = "Fake"
+}
+
+// fakeProviderは外部に接続せずに、codeに渡された名前のユーザーとしてログインさせる認証プロバイダーです
+type fakeProvider struct {
+	callback string
+}
+
+func (p *fakeProvider) Name() string        { return "fake" }
+func (p *fakeProvider) DisplayName() string { return "Fake" }
+
+func (p *fakeProvider) PublicData(options map[string]interface{}) (interface{}, error) {
+	return p.Name(), nil
+}
+
+func (p *fakeProvider) GetBeginAuthURL(state *gomniauthcommon.State, options objx.Map) (string, error) {
+	return p.callback + "?code=guest", nil
+}
+
+func (p *fakeProvider) CompleteAuth(data objx.Map) (*gomniauthcommon.Credentials, error) {
+	code := data.Get("code").Str()
+	if code == "" {
+		return nil, errors.New("fake: no code")
+	}
+	return &gomniauthcommon.Credentials{Map: objx.New(map[string]interface{}{"code": code})}, nil
+}
+
+func (p *fakeProvider) GetUser(creds *gomniauthcommon.Credentials) (gomniauthcommon.User, error) {
+	return &fakeUser{name: creds.Map.Get("code").Str()}, nil
+}
+
+func (p *fakeProvider) Get(creds *gomniauthcommon.Credentials, endpoint string) (objx.Map, error) {
+	return nil, errors.New("fake: not supported")
+}
+
+func (p *fakeProvider) GetClient(creds *gomniauthcommon.Credentials) (*http.Client, error) {
+	return http.DefaultClient, nil
+}
+
+type fakeUser struct {
+	name string
+}
+
+func (u *fakeUser) Email() string                                                { return u.name + "@example.com" }
+func (u *fakeUser) Name() string                                                 { return u.name }
+func (u *fakeUser) Nickname() string                                             { return u.name }
+func (u *fakeUser) AvatarURL() string                                            { return "" }
+func (u *fakeUser) ProviderCredentials() map[string]*gomniauthcommon.Credentials { return nil }
+func (u *fakeUser) IDForProvider(provider string) string                         { return u.name }
+func (u *fakeUser) AuthCode() string                                             { return "" }
+func (u *fakeUser) Data() objx.Map                                               { return objx.New(map[string]interface{}{"name": u.name}) }
+
+// testServerはhttptestで起動したチャットサーバーです
+type testServer struct {
+	*httptest.Server
+	chat *chatServer
+}
+
+// newTestServerは偽の認証プロバイダーを使うサーバーを起動します。
+// configureでテストごとに設定を変えられます。サーバーはテストの終了時に停止します。
+func newTestServer(t *testing.T, configure ...func(*config)) *testServer {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.SecurityKey = "test-security-key"
+	cfg.AdminKey = testAdminKey
+	cfg.Providers = []providerConf{{Name: "fake", ClientID: "id", ClientSecret: "secret"}}
+	cfg.Paths.Avatars = t.TempDir()
+	cfg.Avatars = []string{"gravatar"}
+	for _, f := range configure {
+		f(cfg)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("invalid test config: %s", err)
+	}
+	// newChatServerが書き換えるパッケージの設定を、テストの終了時に戻します
+	savedAvatars, savedAvatarDir, savedTemplates, savedUsers, savedCookieKey := avatars, avatarDir, templates, userStore, cookieKey
+	t.Cleanup(func() {
+		avatars, avatarDir, templates, userStore, cookieKey = savedAvatars, savedAvatarDir, savedTemplates, savedUsers, savedCookieKey
+	})
+	ts := &testServer{}
+	ts.Server = httptest.NewUnstartedServer(nil)
+	ts.Server.Start()
+	cfg.BaseURL = ts.URL
+	chat, err := newChatServer(cfg, nil)
+	if err != nil {
+		t.Fatalf("newChatServer: %s", err)
+	}
+	ts.chat = chat
+	ts.Config.Handler = chat
+	t.Cleanup(func() {
+		chat.Close(context.Background(), "test finished")
+		ts.Close()
+	})
+	return ts
+}
+
+// testUserはログイン済みのユーザーで、クッキーを保持するHTTPクライアントを持っています
+type testUser struct {
+	name   string
+	client *http.Client
+	ts     *testServer
+}
+
+// newClientはリダイレクトをたどらず、クッキーを保持するHTTPクライアントを作ります
+func (ts *testServer) newClient(t *testing.T) *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{
+		Jar:     jar,
+		Timeout: testTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// loginはnameという名前のユーザーとして偽の認証プロバイダーでログインします
+func (ts *testServer) login(t *testing.T, name string) *testUser {
+	t.Helper()
+	u := &testUser{name: name, client: ts.newClient(t), ts: ts}
+	res, err := u.client.Get(ts.URL + "/auth/callback/fake?code=" + url.QueryEscape(name))
+	if err != nil {
+		t.Fatalf("login %s: %s", name, err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusTemporaryRedirect || res.Header.Get("Location") != "/chat" {
+		t.Fatalf("login %s: unexpected response %d %s", name, res.StatusCode, res.Header.Get("Location"))
+	}
+	return u
+}
+
+// getはユーザーのクッキーを付けてpathを取得します
+func (u *testUser) get(t *testing.T, path string) *http.Response {
+	t.Helper()
+	res, err := u.client.Get(u.ts.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s: %s", path, err)
+	}
+	return res
+}
+
+// dialはユーザーとして/roomにwebsocketで接続し、最初に届く未読の通知を読み飛ばします
+func (u *testUser) dial(t *testing.T, query string) *testConn {
+	t.Helper()
+	c, res, err := u.dialRaw(query, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %s (%v)", u.name, err, res)
+	}
+	conn := &testConn{Conn: c, t: t}
+	t.Cleanup(func() { c.Close() })
+	if msg := conn.read(); msg.Type != messageUnread {
+		t.Fatalf("the first message should be the unread notice, got %+v", msg)
+	}
+	return conn
+}
+
+func (u *testUser) dialRaw(query string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	wsURL := "ws" + strings.TrimPrefix(u.ts.URL, "http") + "/room"
+	if query != "" {
+		wsURL += "?" + query
+	}
+	if header == nil {
+		header = http.Header{}
+	}
+	base, _ := url.Parse(u.ts.URL)
+	for _, c := range u.client.Jar.Cookies(base) {
+		header.Add("Cookie", c.String())
+	}
+	dialer := websocket.Dialer{HandshakeTimeout: testTimeout}
+	return dialer.Dial(wsURL, header)
+}
+
+// testConnはテスト用のwebsocket接続です
+type testConn struct {
+	*websocket.Conn
+	t *testing.T
+}
+
+func (c *testConn) send(text string) {
+	c.t.Helper()
+	if err := c.WriteJSON(map[string]string{"Message": text}); err != nil {
+		c.t.Fatalf("send: %s", err)
+	}
+}
+
+func (c *testConn) sendFrame(frame interface{}) {
+	c.t.Helper()
+	if err := c.WriteJSON(frame); err != nil {
+		c.t.Fatalf("send: %s", err)
+	}
+}
+
+func (c *testConn) read() *message {
+	c.t.Helper()
+	c.SetReadDeadline(time.Now().Add(testTimeout))
+	var msg message
+	if err := c.ReadJSON(&msg); err != nil {
+		c.t.Fatalf("read: %s", err)
+	}
+	return &msg
+}
+
+// clientCountはmainのroomに接続中のクライアントの数を管理APIで調べます
+func (ts *testServer) clientCount(t *testing.T) int {
+	t.Helper()
+	req, _ := http.NewRequest("GET", ts.URL+"/admin/rooms", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminKey)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var rooms []adminRoom
+	if err := json.NewDecoder(res.Body).Decode(&rooms); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rooms {
+		if r.Name == "main" {
+			return r.Clients
+		}
+	}
+	return 0
+}
+
+// waitForは条件が満たされるまでtestTimeoutの間待ちます
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIntegrationBroadcast(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.login(t, "alice").dial(t, "")
+	bob := ts.login(t, "bob").dial(t, "")
+	waitFor(t, "both clients to join", func() bool { return ts.clientCount(t) == 2 })
+
+	alice.send("hello bob")
+	for _, c := range []*testConn{alice, bob} {
+		msg := c.read()
+		if msg.Name != "alice" || msg.Message != "hello bob" || msg.ID == 0 {
+			t.Errorf("unexpected broadcast: %+v", msg)
+		}
+	}
+}
+
+func TestIntegrationJoinLeave(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.login(t, "alice")
+	conn := alice.dial(t, "")
+	waitFor(t, "alice to join", func() bool { return ts.clientCount(t) == 1 })
+	conn.send("first")
+	conn.read()
+	conn.Close()
+	waitFor(t, "alice to leave", func() bool { return ts.clientCount(t) == 0 })
+
+	// 再接続したときは切断中のメッセージが送り直されます
+	bob := ts.login(t, "bob").dial(t, "")
+	bob.send("while you were away")
+	bob.read()
+	again := alice.dial(t, "since=1")
+	if msg := again.read(); msg.Message != "while you were away" {
+		t.Errorf("reconnecting should replay missed messages, got %+v", msg)
+	}
+}
+
+func TestIntegrationShutdown(t *testing.T) {
+	ts := newTestServer(t)
+	conn := ts.login(t, "alice").dial(t, "")
+	waitFor(t, "alice to join", func() bool { return ts.clientCount(t) == 1 })
+	ts.chat.Close(context.Background(), "bye")
+	if msg := conn.read(); msg.Type != messageSystem || msg.Message != "bye" {
+		t.Errorf("expected the shutdown notice, got %+v", msg)
+	}
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("expected a going away close frame, got %v", err)
+	}
+}
+
+func TestIntegrationErrors(t *testing.T) {
+	ts := newTestServer(t)
+	anonymous := &testUser{name: "anonymous", client: ts.newClient(t), ts: ts}
+
+	res := anonymous.get(t, "/chat")
+	res.Body.Close()
+	if res.StatusCode != http.StatusTemporaryRedirect || res.Header.Get("Location") != "/login" {
+		t.Errorf("/chat without login should redirect to /login, got %d", res.StatusCode)
+	}
+	if _, res, err := anonymous.dialRaw("", nil); err == nil || res == nil || res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("/room without login should be rejected with 401, got %v", res)
+	}
+
+	alice := ts.login(t, "alice")
+	header := http.Header{"Origin": {"https://evil.example.com"}}
+	if _, res, err := alice.dialRaw("", header); err == nil || res == nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("cross origin websockets should be rejected with 403, got %v", res)
+	}
+
+	res = alice.get(t, "/auth/callback/unknown")
+	res.Body.Close()
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("unknown auth providers should fail, got %d", res.StatusCode)
+	}
+	res = alice.get(t, "/chat")
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("/chat after login should render, got %d", res.StatusCode)
+	}
+}
+
+func TestIntegrationProfile(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.login(t, "alice")
+	id := currentUserIDFromJar(t, alice)
+
+	res := alice.get(t, "/api/users/"+id)
+	var profile map[string]interface{}
+	json.NewDecoder(res.Body).Decode(&profile)
+	res.Body.Close()
+	if profile["display_name"] != "alice" {
+		t.Fatalf("the first login should create a profile, got %v", profile)
+	}
+
+	res = alice.get(t, "/profile")
+	res.Body.Close()
+	var token string
+	base, _ := url.Parse(ts.URL)
+	for _, c := range alice.client.Jar.Cookies(base) {
+		if c.Name == csrfCookieName {
+			token = c.Value
+		}
+	}
+	res, err := alice.client.PostForm(ts.URL+"/profile", url.Values{
+		csrfFieldName:  {token},
+		"display_name": {"Alice Liddell"},
+		"status":       {"down the rabbit hole"},
+		"avatar":       {"gravatar"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("profile update failed: %d", res.StatusCode)
+	}
+
+	conn := alice.dial(t, "")
+	conn.send("hi")
+	if msg := conn.read(); msg.Name != "Alice Liddell" {
+		t.Errorf("messages should use the updated display name, got %q", msg.Name)
+	}
+	res = alice.get(t, "/api/users/nobody")
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown users should return 404, got %d", res.StatusCode)
+	}
+}
+
+// uploadはユーザーとしてアバターの画像をアップロードします。fieldsはフォームに足す項目です
+func (u *testUser) upload(t *testing.T, filename string, fields map[string]string) *http.Response {
+	t.Helper()
+	res := u.get(t, "/upload")
+	res.Body.Close()
+	base, _ := url.Parse(u.ts.URL)
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, c := range u.client.Jar.Cookies(base) {
+		if c.Name == csrfCookieName {
+			mw.WriteField(csrfFieldName, c.Value)
+		}
+	}
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	fw, _ := mw.CreateFormFile("avatarFile", filename)
+	fw.Write([]byte("png"))
+	mw.Close()
+	res, err := u.client.Post(u.ts.URL+"/uploader", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatalf("upload %s: %s", u.name, err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func TestIntegrationUpload(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.login(t, "alice")
+	bob := ts.login(t, "bob")
+	aliceID, bobID := currentUserIDFromJar(t, alice), currentUserIDFromJar(t, bob)
+
+	// フォームのuseridは無視され、ログイン中のユーザーのアバターとして保存されます
+	if res := alice.upload(t, "me.png", map[string]string{"userid": bobID}); res.StatusCode != http.StatusOK {
+		t.Fatalf("upload failed: %d", res.StatusCode)
+	}
+	if _, err := os.Stat(filepath.Join(avatarDir, aliceID+".png")); err != nil {
+		t.Errorf("the avatar should be stored under the uploader's ID: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(avatarDir, bobID+".png")); !os.IsNotExist(err) {
+		t.Errorf("uploads should not replace another user's avatar: %v", err)
+	}
+
+	guest := &testUser{name: "guest", client: ts.newClient(t), ts: ts}
+	if res := guest.upload(t, "me.png", map[string]string{"userid": bobID}); res.StatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("uploads should require login, got %d", res.StatusCode)
+	}
+}
+
+func TestUploaderPathTraversal(t *testing.T) {
+	savedKey, savedDir := cookieKey, avatarDir
+	defer func() { cookieKey, avatarDir = savedKey, savedDir }()
+	cookieKey = []byte("test-key")
+	dir := t.TempDir()
+	avatarDir = filepath.Join(dir, "avatars")
+	os.Mkdir(avatarDir, 0755)
+
+	// 署名されたクッキーでも、IDに ../ を含む場合はavatarDirの外に書き込ませません
+	w := httptest.NewRecorder()
+	setAuthCookie(w, &UserProfile{ID: "../templates/chat", Avatar: "gravatar"})
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("avatarFile", "x.html")
+	fw.Write([]byte("<script>"))
+	mw.Close()
+	req := httptest.NewRequest("POST", "/uploader", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.AddCookie(w.Result().Cookies()[0])
+	res := httptest.NewRecorder()
+	uploaderHandler(res, req)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("uploads outside the avatar directory should be rejected, got %d", res.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "templates", "chat.html")); !os.IsNotExist(err) {
+		t.Errorf("nothing should be written outside the avatar directory: %v", err)
+	}
+}
+
+func TestIntegrationThreads(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.login(t, "alice").dial(t, "")
+	bob := ts.login(t, "bob").dial(t, "")
+	carol := ts.login(t, "carol").dial(t, "")
+	waitFor(t, "all clients to join", func() bool { return ts.clientCount(t) == 3 })
+	conns := []*testConn{alice, bob, carol}
+
+	alice.send("root")
+	var root uint64
+	for _, c := range conns {
+		root = c.read().ID
+	}
+	// 返信した人はスレッドを購読し、他の人には要約が届きます
+	bob.sendFrame(map[string]interface{}{"Message": "first reply", "ParentID": root})
+	if msg := bob.read(); msg.Type != "" || msg.ParentID != root || msg.Message != "first reply" {
+		t.Errorf("the author should receive the reply itself, got %+v", msg)
+	}
+	for _, c := range []*testConn{alice, carol} {
+		if msg := c.read(); msg.Type != messageThread || msg.ParentID != root || msg.Replies != 1 || msg.When.IsZero() {
+			t.Errorf("non-subscribers should receive a summary, got %+v", msg)
+		} else if msg.Message != "" || msg.Name != "" || msg.AvatarURL != "" {
+			t.Errorf("summaries should not carry the reply itself, got %+v", msg)
+		}
+	}
+
+	// 返信への返信は同じスレッドに入ります
+	carol.sendFrame(map[string]interface{}{"Type": messageSubscribe, "ParentID": root})
+	// 購読の通知の後に送ったメッセージが届けば、購読は済んでいます
+	carol.send("subscribed")
+	for _, c := range conns {
+		c.read()
+	}
+	alice.sendFrame(map[string]interface{}{"Message": "nested", "ParentID": root + 1})
+	for _, c := range conns {
+		msg := c.read()
+		if msg.ParentID != root || msg.Type != "" {
+			t.Errorf("subscribers should receive the reply in the thread, got %+v", msg)
+		}
+	}
+
+	res := ts.login(t, "dave").get(t, fmt.Sprintf("/api/history/thread?id=%d", root+1))
+	var thread []*message
+	json.NewDecoder(res.Body).Decode(&thread)
+	res.Body.Close()
+	if len(thread) != 3 || thread[0].ID != root || thread[2].Message != "nested" {
+		t.Errorf("unexpected thread: %+v", thread)
+	}
+	res = ts.login(t, "erin").get(t, "/api/history/thread?id=999")
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown threads should return 404, got %d", res.StatusCode)
+	}
+}
+
+// currentUserIDFromJarはユーザーのauthクッキーからユーザーのIDを取り出します
+func currentUserIDFromJar(t *testing.T, u *testUser) string {
+	t.Helper()
+	base, _ := url.Parse(u.ts.URL)
+	for _, c := range u.client.Jar.Cookies(base) {
+		if c.Name == "auth" {
+			data, err := parseAuthCookie(c.Value)
+			if err != nil {
+				t.Fatalf("%s has a bad auth cookie: %s", u.name, err)
+			}
+			return data.Get("userid").Str()
+		}
+	}
+	t.Fatalf("%s has no auth cookie", u.name)
+	return ""
+}
@@ -1,18 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
-	"sync"
-	"text/template"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/joho/godotenv"
-	"github.com/stretchr/gomniauth"
-	"github.com/stretchr/gomniauth/providers/google"
 	"github.com/stretchr/objx"
 	"github.com/taitai9847/goblueprints/ch1/trace"
 )
@@ -23,72 +21,134 @@ var avatars Avatar = TryAvatars{
 	UseGravatar,
 }
 
+// pageDataはページごとにテンプレートへ渡すデータを追加します
+type pageData func(r *http.Request, data map[string]interface{})
+
 type templateHandler struct {
-	once     sync.Once
 	filename string
-	templ    *template.Template
+	data     []pageData
 }
 
 func (t *templateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	t.once.Do(func() {
-		t.templ = template.Must(template.ParseFiles(filepath.Join("templates", t.filename)))
-	})
+	_, span := trace.StartSpan(r.Context(), "template")
+	span.SetAttr("template", t.filename)
+	defer span.Finish()
 	data := map[string]interface{}{
-		"Host": r.Host,
+		"Host":      r.Host,
+		"CSRFToken": csrfToken(w, r),
+	}
+	if userData, err := authData(r); err == nil {
+		data["UserData"] = userData
+	}
+	for _, provide := range t.data {
+		provide(r, data)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.render(w, t.filename, data); err != nil {
+		log.Println("テンプレートの描画に失敗しました:", t.filename, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// loginProvidersはログイン画面に設定済みの認証プロバイダーを渡します
+func loginProviders(providers []providerConf) pageData {
+	type loginProvider struct {
+		Name  string
+		Title string
 	}
-	if authCookie, err := r.Cookie("auth"); err == nil {
-		data["UserData"] = objx.MustFromBase64(authCookie.Value)
+	var list []loginProvider
+	for _, p := range providers {
+		list = append(list, loginProvider{Name: p.Name, Title: providerTitles[p.Name]})
 	}
+	return func(r *http.Request, data map[string]interface{}) {
+		data["Providers"] = list
+	}
+}
 
-	t.templ.Execute(w, data)
+var providerTitles = map[string]string{
+	"google":   "Google",
+	"github":   "GitHub",
+	"facebook": "Facebook",
+}
+
+// currentAvatarはアップロード画面に現在のアバターを渡します
+func currentAvatar(r *http.Request, data map[string]interface{}) {
+	if userData, ok := data["UserData"].(objx.Map); ok {
+		data["CurrentAvatar"] = userData.Get("avatar_url").Str()
+	}
 }
 
 func main() {
+	if err := run(); err != nil {
+		log.Fatalln(err)
+	}
+}
 
-	err := godotenv.Load("../../.env")
+// runはサーバーを起動し、シグナルを受けて停止するまで戻りません。
+// log.Fatalで抜けるとdeferが実行されないため、エラーは呼び出し元に返します
+func run() error {
+
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		return nil
+	}
 	if err != nil {
-		fmt.Printf("読み込み出来ませんでした: %v", err)
-	}
-	securityKey := os.Getenv("APP_SECURITY_KEY")
-	clientId := os.Getenv("CLIENT_ID")
-	clientSecret := os.Getenv("CLIENT_SECRET")
-
-	var addr = flag.String("addr", ":8080", "The addr of the application.")
-	flag.Parse()
-
-	gomniauth.SetSecurityKey(securityKey)
-	gomniauth.WithProviders(
-		google.New(clientId, clientSecret, "http://localhost:8080/auth/callback/google"),
-	)
-
-	r := newRoom()
-	r.tracer = trace.New(os.Stdout)
-
-	http.Handle("/chat", MustAuth(&templateHandler{filename: "chat.html"}))
-	http.Handle("/login", &templateHandler{filename: "login.html"})
-	http.HandleFunc("/auth/", loginHandler)
-	http.Handle("/room", r)
-	http.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
-		http.SetCookie(w, &http.Cookie{
-			Name:   "auth",
-			Value:  "",
-			Path:   "/",
-			MaxAge: -1,
-		})
-		w.Header()["Location"] = []string{"/chat"}
-		w.WriteHeader(http.StatusTemporaryRedirect)
-	})
-	http.Handle("/upload", &templateHandler{filename: "upload.html"})
-	http.HandleFunc("/uploader", uploaderHandler)
-	http.Handle("/avatars/",
-		http.StripPrefix("/avatars/",
-			http.FileServer(http.Dir("./avatars"))))
-
-	go r.run()
-
-	log.Println("Starting web server on", *addr)
-
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatal("ListenAndServe:", err)
+		return err
+	}
+
+	// room.runがログ出力で止まらないよう非同期で書き出します
+	tracer := trace.Async(trace.New(os.Stdout), 1024)
+	defer tracer.Close()
+
+	server, err := newChatServer(cfg, tracer)
+	if err != nil {
+		return fmt.Errorf("サーバーの準備に失敗しました: %w", err)
+	}
+
+	var spans trace.Exporter
+	if cfg.SpanLog != "" {
+		f := trace.NewRotatingFile(cfg.SpanLog, 10<<20, 24*time.Hour)
+		defer f.Close()
+		spans = trace.NewJSONExporter(f)
+	}
+
+	srv := &http.Server{
+		Addr:    cfg.Addr,
+		Handler: trace.Middleware("http", spans, server),
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Println("Starting web server on", cfg.Addr)
+		var err error
+		if cfg.CertFile != "" {
+			err = srv.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		serveErr <- err
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-sig:
+	case err := <-serveErr:
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+		defer cancel()
+		server.Close(ctx, "サーバーを停止します")
+		return fmt.Errorf("ListenAndServe: %w", err)
+	}
+	log.Println("Shutting down...")
+
+	// roomの切断とShutdownの両方をshutdown_timeoutの間に終わらせます
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+	// websocketはShutdownの対象外なので、先にroomを閉じて切断します
+	server.Close(ctx, "サーバーを停止します")
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("Shutdown:", err)
+		// 書き込みが終わらないSSEの接続などを強制的に閉じます
+		srv.Close()
 	}
+	return nil
 }
@@ -4,9 +4,43 @@ import (
 	"time"
 )
 
+// メッセージの種類です。通常のチャットのメッセージはTypeが空です
+const (
+	// messageAckはクライアントからの既読の通知で、IDまで読んだことを表します
+	messageAck = "ack"
+	// messageUnreadは接続時にサーバーから送る未読数の通知です
+	messageUnread = "unread"
+	// messageSystemはサーバーや管理者からのお知らせです
+	messageSystem = "system"
+	// messageSubscribeとmessageUnsubscribeはクライアントからの、
+	// ParentIDのスレッドの返信を受け取る・受け取らないという通知です
+	messageSubscribe   = "subscribe"
+	messageUnsubscribe = "unsubscribe"
+	// messageThreadはスレッドを購読していないクライアントに送る返信の要約です
+	messageThread = "thread"
+)
+
 type message struct {
+	// IDはroomをまたいで一意な、送られた順に増える番号
+	ID uint64
+	// Typeはメッセージの種類
+	Type string `json:",omitempty"`
+	// ParentIDは返信先のスレッドの最初のメッセージのID
+	ParentID uint64 `json:",omitempty"`
+	// Roomはメッセージが送られたroomの名前
+	Room      string
 	Name      string
 	Message   string
 	When      time.Time
 	AvatarURL string
+	// Unreadは未読の通知で、LastReadより後のメッセージの数
+	Unread int `json:",omitempty"`
+	// LastReadは未読の通知で、最後に読んだメッセージのID
+	LastRead uint64 `json:",omitempty"`
+	// Repliesはスレッドの要約で、スレッドの返信の数
+	Replies int `json:",omitempty"`
+}
+
+func systemMessage(room, text string) *message {
+	return &message{Type: messageSystem, Room: room, Name: "system", Message: text, When: time.Now()}
 }
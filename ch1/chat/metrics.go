@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// chatMetrics はチャットサーバーの稼働状況を集計し、
+// /metrics でPrometheusのテキスト形式として公開します
+type chatMetrics struct {
+	roomsActive      int64
+	clientsConnected int64
+	messagesTotal    uint64
+	sendDrops        uint64
+	messageRate      *rateMeter
+	uploads          *labeledCounter
+	authFailures     *labeledCounter
+}
+
+func newChatMetrics() *chatMetrics {
+	return &chatMetrics{
+		messageRate:  newRateMeter(time.Minute),
+		uploads:      newLabeledCounter("result"),
+		authFailures: newLabeledCounter("reason"),
+	}
+}
+
+var metrics = newChatMetrics()
+
+func (m *chatMetrics) roomOpened()   { atomic.AddInt64(&m.roomsActive, 1) }
+func (m *chatMetrics) roomClosed()   { atomic.AddInt64(&m.roomsActive, -1) }
+func (m *chatMetrics) clientJoined() { atomic.AddInt64(&m.clientsConnected, 1) }
+func (m *chatMetrics) clientLeft()   { atomic.AddInt64(&m.clientsConnected, -1) }
+func (m *chatMetrics) sendDropped()  { atomic.AddUint64(&m.sendDrops, 1) }
+
+func (m *chatMetrics) messageForwarded() {
+	atomic.AddUint64(&m.messagesTotal, 1)
+	m.messageRate.mark(time.Now())
+}
+
+func (m *chatMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.writeTo(w, time.Now())
+}
+
+func (m *chatMetrics) writeTo(w io.Writer, now time.Time) {
+	writeMetric(w, "chat_rooms_active", "gauge", "Number of rooms currently running.",
+		atomic.LoadInt64(&m.roomsActive))
+	writeMetric(w, "chat_clients_connected", "gauge", "Number of clients currently connected over websocket or SSE.",
+		atomic.LoadInt64(&m.clientsConnected))
+	writeMetric(w, "chat_messages_total", "counter", "Total number of messages forwarded by rooms.",
+		atomic.LoadUint64(&m.messagesTotal))
+	writeMetric(w, "chat_messages_per_second", "gauge", "Messages forwarded per second averaged over the last minute.",
+		m.messageRate.rate(now))
+	writeMetric(w, "chat_send_buffer_drops_total", "counter", "Messages dropped because a client's send buffer was full.",
+		atomic.LoadUint64(&m.sendDrops))
+	m.uploads.writeTo(w, "chat_uploads_total", "Avatar uploads handled by the uploader.")
+	m.authFailures.writeTo(w, "chat_auth_failures_total", "Failed authentication attempts.")
+}
+
+func writeMetric(w io.Writer, name, typ, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, value)
+}
+
+// labeledCounter はラベルの値ごとに数を数えるカウンターです
+type labeledCounter struct {
+	label  string
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func newLabeledCounter(label string) *labeledCounter {
+	return &labeledCounter{label: label, counts: map[string]uint64{}}
+}
+
+func (c *labeledCounter) inc(value string) {
+	c.mu.Lock()
+	c.counts[value]++
+	c.mu.Unlock()
+}
+
+func (c *labeledCounter) writeTo(w io.Writer, name, help string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	values := make([]string, 0, len(c.counts))
+	for v := range c.counts {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	for _, v := range values {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, c.label, v, c.counts[v])
+	}
+}
+
+// rateMeter は直近windowの間に記録された回数から1秒あたりの件数を求めます
+type rateMeter struct {
+	mu      sync.Mutex
+	window  time.Duration
+	buckets []uint64
+	seconds []int64
+}
+
+func newRateMeter(window time.Duration) *rateMeter {
+	n := int(window / time.Second)
+	if n < 1 {
+		n = 1
+	}
+	return &rateMeter{
+		window:  time.Duration(n) * time.Second,
+		buckets: make([]uint64, n),
+		seconds: make([]int64, n),
+	}
+}
+
+func (r *rateMeter) mark(now time.Time) {
+	sec := now.Unix()
+	i := int(sec % int64(len(r.buckets)))
+	r.mu.Lock()
+	if r.seconds[i] != sec {
+		r.seconds[i] = sec
+		r.buckets[i] = 0
+	}
+	r.buckets[i]++
+	r.mu.Unlock()
+}
+
+func (r *rateMeter) rate(now time.Time) float64 {
+	oldest := now.Unix() - int64(len(r.buckets)) + 1
+	var total uint64
+	r.mu.Lock()
+	for i, sec := range r.seconds {
+		if sec >= oldest {
+			total += r.buckets[i]
+		}
+	}
+	r.mu.Unlock()
+	return float64(total) / r.window.Seconds()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsOutput(t *testing.T) {
+	m := newChatMetrics()
+	m.roomOpened()
+	m.clientJoined()
+	m.clientJoined()
+	m.clientLeft()
+	m.sendDropped()
+	m.uploads.inc("success")
+	m.authFailures.inc("no_cookie")
+	m.authFailures.inc("no_cookie")
+
+	var buf bytes.Buffer
+	m.writeTo(&buf, time.Now())
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE chat_rooms_active gauge\nchat_rooms_active 1\n",
+		"chat_clients_connected 1\n",
+		"chat_send_buffer_drops_total 1\n",
+		`chat_uploads_total{result="success"} 1`,
+		`chat_auth_failures_total{reason="no_cookie"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics output should contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRateMeter(t *testing.T) {
+	r := newRateMeter(10 * time.Second)
+	now := time.Unix(1000, 0)
+	for i := 0; i < 20; i++ {
+		r.mark(now)
+	}
+	if rate := r.rate(now); rate != 2 {
+		t.Errorf("rate should be 2/s, got %v", rate)
+	}
+	if rate := r.rate(now.Add(time.Minute)); rate != 0 {
+		t.Errorf("old marks should fall out of the window, got %v", rate)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// writeFileAtomic はdataをpathに書き込みます。
+// 書き込み途中で止まっても壊れないよう、一時ファイルに書いてから置き換えます
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// batchedSave は頻繁な更新をdelayの間まとめて、saveを1回だけ呼びます。
+// saveはmuを持った状態で呼ばれます。schedule, cancel, closeもmuを持った状態で呼んでください
+type batchedSave struct {
+	mu    sync.Locker
+	delay time.Duration
+	save  func() error
+	// timerは保存待ちの更新があるあいだだけ設定されます
+	timer *time.Timer
+	// errは裏で保存したときのエラーで、次のscheduleかcloseで返します
+	err error
+}
+
+// schedule はdelayの後に保存するよう予約し、前回の裏での保存のエラーを返します
+func (b *batchedSave) schedule() error {
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.delay, b.flush)
+	}
+	err := b.err
+	b.err = nil
+	return err
+}
+
+func (b *batchedSave) flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.timer = nil
+	b.err = b.save()
+}
+
+// cancel は予約を取り消します。予約があった場合はtrueを返します
+func (b *batchedSave) cancel() bool {
+	if b.timer == nil {
+		return false
+	}
+	b.timer.Stop()
+	b.timer = nil
+	return true
+}
+
+// close は予約があればすぐに保存し、まだ返していないエラーと合わせて返します
+func (b *batchedSave) close() error {
+	err := b.err
+	b.err = nil
+	if b.cancel() {
+		err = b.save()
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// receiptStore はユーザーごと、roomごとに最後に読んだメッセージのIDを保持します。
+// ファイルを指定した場合はJSONとして保存します。既読の通知はメッセージのたびに届くので、
+// 更新のたびには書き込まず、receiptSaveDelayの間の更新をまとめて保存します。
+type receiptStore struct {
+	mu   sync.Mutex
+	path string
+	// positions[userID][room] が最後に読んだメッセージのIDです
+	positions map[string]map[string]uint64
+	// batchは保存をまとめます。裏での保存のエラーは次のMarkReadで返します
+	batch batchedSave
+}
+
+const receiptSaveDelay = time.Second
+
+func newReceiptStore() *receiptStore {
+	s := &receiptStore{positions: map[string]map[string]uint64{}}
+	s.batch = batchedSave{mu: &s.mu, delay: receiptSaveDelay, save: s.save}
+	return s
+}
+
+// openReceipts はpathに保存された既読位置を読み込みます。ファイルがなければ空から始めます
+func openReceipts(path string) (*receiptStore, error) {
+	s := newReceiptStore()
+	s.path = path
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.positions); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LastRead はuserIDがroomで最後に読んだメッセージのIDを返します。まだ読んでいなければ0です
+func (s *receiptStore) LastRead(userID, room string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.positions[userID][room]
+}
+
+// MarkRead は既読位置をidまで進めます。今より前のIDが渡された場合は何もしません
+func (s *receiptStore) MarkRead(userID, room string, id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rooms, ok := s.positions[userID]
+	if !ok {
+		rooms = map[string]uint64{}
+		s.positions[userID] = rooms
+	}
+	if id <= rooms[room] {
+		return nil
+	}
+	rooms[room] = id
+	if s.path == "" {
+		return nil
+	}
+	return s.batch.schedule()
+}
+
+// Close は保存待ちの更新を書き出します
+func (s *receiptStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.batch.close()
+}
+
+func (s *receiptStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(s.positions)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReceiptStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	s, err := openReceipts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.MarkRead("abc", "main", 5)
+	s.MarkRead("abc", "main", 3)
+	if got := s.LastRead("abc", "main"); got != 5 {
+		t.Errorf("MarkRead should never move the position backwards, got %d", got)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("MarkRead should batch saves instead of writing every update: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = openReceipts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.LastRead("abc", "main"); got != 5 {
+		t.Errorf("openReceipts should reload saved positions, got %d", got)
+	}
+	if got := s.LastRead("abc", "other"); got != 0 {
+		t.Errorf("unknown rooms should start unread, got %d", got)
+	}
+}
+
+func TestUnreadNotice(t *testing.T) {
+	r := newRoom("main")
+	for i := 0; i < 4; i++ {
+		r.history.Append(&message{Room: "main", Message: "hi"})
+	}
+	c := &client{userData: map[string]interface{}{"userid": "abc"}}
+	r.receipts.MarkRead("abc", "main", 1)
+	notice := r.unreadNotice(c)
+	if notice.Type != messageUnread || notice.Unread != 3 || notice.LastRead != 1 {
+		t.Errorf("unexpected unread notice: %+v", notice)
+	}
+}
+
+func TestMarkReadClamp(t *testing.T) {
+	r := newRoom("main")
+	r.history.Append(&message{Room: "main", Message: "hi"})
+	c := &client{userData: map[string]interface{}{"userid": "abc"}}
+	r.markRead(c, 100)
+	if got := r.receipts.LastRead("abc", "main"); got != 1 {
+		t.Errorf("markRead should not move past the last message, got %d", got)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// renderer はレイアウトとパーシャルを共有するページテンプレートを描画します。
+// layouts/*.html と partials/*.html はすべてのページで使え、
+// 各ページは "base" レイアウトの title, head, content, scripts ブロックを定義します。
+type renderer struct {
+	files fs.FS
+	// dev がtrueのときはファイルの変更を検知してテンプレートを読み直します
+	dev bool
+
+	mu     sync.Mutex
+	pages  map[string]*template.Template
+	loaded time.Time
+}
+
+func newRenderer(files fs.FS, dev bool) *renderer {
+	return &renderer{files: files, dev: dev, pages: map[string]*template.Template{}}
+}
+
+// embeddedRenderer はバイナリに埋め込まれたテンプレートを使うrendererを返します
+func embeddedRenderer() *renderer {
+	files, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		panic(err)
+	}
+	return newRenderer(files, false)
+}
+
+// dirRenderer はdirのテンプレートを変更のたびに読み直すrendererを返します
+func dirRenderer(dir string) *renderer {
+	return newRenderer(os.DirFS(dir), true)
+}
+
+var templates = embeddedRenderer()
+
+// render はページnameをdataで描画してwに書き出します。
+// 途中で失敗したときに中途半端な出力が残らないよう、一度バッファに描画します。
+func (r *renderer) render(w io.Writer, name string, data interface{}) error {
+	t, err := r.page(name)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, "base", data); err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+func (r *renderer) page(name string) (*template.Template, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dev {
+		changed, err := r.changedSince(r.loaded)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			r.pages = map[string]*template.Template{}
+			r.loaded = time.Now()
+		}
+	}
+	if t, ok := r.pages[name]; ok {
+		return t, nil
+	}
+	t, err := r.parse(name)
+	if err != nil {
+		return nil, err
+	}
+	r.pages[name] = t
+	return t, nil
+}
+
+func (r *renderer) parse(name string) (*template.Template, error) {
+	shared, err := r.sharedFiles()
+	if err != nil {
+		return nil, err
+	}
+	t := template.New(name)
+	for _, file := range append(shared, name) {
+		b, err := fs.ReadFile(r.files, file)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %v", file, err)
+		}
+		tt := t
+		if file != name {
+			tt = t.New(file)
+		}
+		if _, err := tt.Parse(string(b)); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (r *renderer) sharedFiles() ([]string, error) {
+	var files []string
+	for _, pattern := range []string{"layouts/*.html", "partials/*.html"} {
+		matches, err := fs.Glob(r.files, pattern)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+func (r *renderer) changedSince(t time.Time) (bool, error) {
+	changed := false
+	err := fs.WalkDir(r.files, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(p) != ".html" {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(t) {
+			changed = true
+			return fs.SkipDir
+		}
+		return nil
+	})
+	return changed, err
+}
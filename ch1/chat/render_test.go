@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmbeddedRenderer(t *testing.T) {
+	r := embeddedRenderer()
+	var buf bytes.Buffer
+	err := r.render(&buf, "chat.html", map[string]interface{}{
+		"UserData":  map[string]interface{}{"name": "<script>mat</script>"},
+		"CSRFToken": "token",
+	})
+	if err != nil {
+		t.Fatalf("render failed: %s", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "<title>Chat</title>") {
+		t.Error("chat.html should be rendered inside the base layout")
+	}
+	if strings.Contains(out, "<script>mat</script>") {
+		t.Error("user data should be HTML escaped")
+	}
+	if !strings.Contains(out, `name="csrf_token" value="token"`) {
+		t.Error("the logout partial should be rendered")
+	}
+}
+
+func TestDirRendererReload(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "layouts"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "layouts", "base.html"),
+		[]byte(`{{define "base"}}[{{block "content" .}}{{end}}]{{end}}`), 0644)
+	page := filepath.Join(dir, "page.html")
+	ioutil.WriteFile(page, []byte(`{{define "content"}}one{{end}}`), 0644)
+
+	r := dirRenderer(dir)
+	var buf bytes.Buffer
+	if err := r.render(&buf, "page.html", nil); err != nil || buf.String() != "[one]" {
+		t.Fatalf("render = %q, %v", buf.String(), err)
+	}
+
+	ioutil.WriteFile(page, []byte(`{{define "content"}}two{{end}}`), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(page, later, later)
+	buf.Reset()
+	if err := r.render(&buf, "page.html", nil); err != nil || buf.String() != "[two]" {
+		t.Errorf("dev renderer should reload changed templates, got %q, %v", buf.String(), err)
+	}
+}
@@ -1,83 +1,306 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/stretchr/objx"
 	"github.com/taitai9847/goblueprints/ch1/trace"
 )
 
 type room struct {
+	// nameはroomの名前で、履歴の検索や書き出しに使います
+	name    string
 	forward chan *message
 	join    chan *client
 	leave   chan *client
 	clients map[*client]bool
 	tracer  trace.Tracer
+	// upgraderはwebsocketへのアップグレードに使います
+	upgrader *websocket.Upgrader
+	// messageBufferSizeは各クライアントの送信バッファの大きさです
+	messageBufferSize int
+	// historyはroomに送られたメッセージを保存します
+	history *historyStore
+	// receiptsはユーザーごとの既読位置を保存します
+	receipts *receiptStore
+	// execはrunのgoroutineで実行する関数を受け取ります
+	exec chan func()
+	// sessionsはSSEで接続しているクライアントをセッションIDで引くためのもの
+	sessions   map[string]*sseTransport
+	sessionsMu sync.Mutex
+	// connsは接続を処理しているserveの数です。serveは書き込みgoroutineの終了まで数に含まれます
+	conns sync.WaitGroup
+	// quitはroomを停止するときの理由を受け取ります
+	quit chan string
+	// doneはroomが停止したときに閉じられます
+	done chan struct{}
 }
 
-func newRoom() *room {
+func newRoom(name string) *room {
 	return &room{
-		forward: make(chan *message),
-		join:    make(chan *client),
-		leave:   make(chan *client),
-		clients: make(map[*client]bool),
-		tracer:  trace.Off(),
+		name:     name,
+		forward:  make(chan *message),
+		join:     make(chan *client),
+		leave:    make(chan *client),
+		clients:  make(map[*client]bool),
+		tracer:   trace.Off(),
+		history:  newHistoryStore(historyBufferSize),
+		receipts: newReceiptStore(),
+		exec:     make(chan func()),
+		sessions: make(map[string]*sseTransport),
+		quit:     make(chan string),
+		done:     make(chan struct{}),
+		upgrader: &websocket.Upgrader{
+			ReadBufferSize:  socketBufferSize,
+			WriteBufferSize: socketBufferSize,
+		},
+		messageBufferSize: messageBufferSize,
 	}
 }
 
 func (r *room) run() {
+	metrics.roomOpened()
+	defer metrics.roomClosed()
 	for {
 		select {
 		case client := <-r.join:
 			//joining
 			r.clients[client] = true
+			metrics.clientJoined()
 			r.tracer.Trace("New client joined")
+			if client.since > 0 {
+				r.replay(client)
+			}
 		case client := <-r.leave:
 			//leaving
-			delete(r.clients, client)
-			close(client.send)
+			r.disconnect(client)
 			r.tracer.Trace("Client left")
 		case msg := <-r.forward:
 			r.tracer.Trace("Message received: ", msg.Message)
-			//forward message to all clients
-			for client := range r.clients {
-				client.send <- msg
-			}
+			r.store(msg)
+			r.broadcast(msg)
+		case f := <-r.exec:
+			f()
+		case reason := <-r.quit:
+			r.shutdown(reason)
+			return
+		}
+	}
+}
+
+// storeはmsgをこのroomのメッセージとして履歴に追加します
+func (r *room) store(msg *message) {
+	msg.Room = r.name
+	if err := r.history.Append(msg); err != nil {
+		r.tracer.Trace(trace.Error, "Failed to store message: ", err)
+	}
+}
+
+// disconnectはclientをroomから外して送信チャネルを閉じます。
+// 管理APIで切断したクライアントが後からleaveしても二重に閉じないようにしています。
+func (r *room) disconnect(c *client) {
+	if _, ok := r.clients[c]; !ok {
+		return
+	}
+	delete(r.clients, c)
+	close(c.send)
+	metrics.clientLeft()
+}
+
+// doはfをrunのgoroutineで実行し、終わるまで待ちます。
+// roomが停止していてfを実行できなかった場合はfalseを返します。
+func (r *room) do(f func()) bool {
+	done := make(chan struct{})
+	select {
+	case r.exec <- func() { f(); close(done) }:
+		<-done
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+func (r *room) broadcast(msg *message) {
+	metrics.messageForwarded()
+	summary := r.summarize(msg)
+	//forward message to all clients
+	for client := range r.clients {
+		select {
+		case client.send <- client.view(msg, summary):
+		default:
+			// 送信バッファが一杯のクライアントのためにroom全体を止めない
+			metrics.sendDropped()
+			r.tracer.Trace(trace.Warn, "Send buffer full, message dropped")
+		}
+	}
+}
+
+// shutdownは送信待ちのメッセージを配信し、停止を通知してから全クライアントを切断します
+func (r *room) shutdown(reason string) {
+	for drained := false; !drained; {
+		select {
+		case msg := <-r.forward:
+			r.store(msg)
+			r.broadcast(msg)
+		default:
+			drained = true
 		}
 	}
+	r.broadcast(systemMessage(r.name, reason))
+	close(r.done)
+	for client := range r.clients {
+		r.disconnect(client)
+	}
+	r.tracer.Trace("Room closed: ", reason)
+}
+
+// closeはroomを停止し、すべてのserveが戻るまで待ちます。serveは書き込みgoroutineの
+// 終了を待ってから戻るので、nilを返した時点で送信中のクライアントは残っていません。
+// ctxが先に終わった場合は、残りのクライアントを待たずにctxのエラーを返します
+func (r *room) close(ctx context.Context, reason string) error {
+	select {
+	case r.quit <- reason:
+		<-r.done
+	case <-r.done:
+	}
+	finished := make(chan struct{})
+	go func() {
+		r.conns.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *room) closed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
 }
 
 const (
 	socketBufferSize  = 1024
 	messageBufferSize = 256
+	// historyBufferSizeはroomごとにメモリに残す履歴の件数です
+	historyBufferSize = 10000
 )
 
-var upgrader = &websocket.Upgrader{ReadBufferSize: socketBufferSize, WriteBufferSize: socketBufferSize}
-
 func (r *room) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	socket, err := upgrader.Upgrade(w, req, nil)
+	_, span := trace.StartSpan(req.Context(), "room.session")
+	defer span.Finish()
+	userData, err := authData(req)
 	if err != nil {
-		log.Fatal("ServeHTTP:", err)
+		http.Error(w, "クッキーの取得に失敗しました", http.StatusUnauthorized)
 		return
 	}
-
-	authCookie, err := req.Cookie("auth")
+	socket, err := r.upgrader.Upgrade(w, req, nil)
 	if err != nil {
-		log.Fatal("クッキーの取得に失敗しました", err)
+		// Upgradeがエラーのレスポンスを返しているので、ここでは記録だけします
+		log.Println("ServeHTTP:", err)
 		return
 	}
-	client := &client{
-		socket:   socket,
-		send:     make(chan *message, messageBufferSize),
+	r.serve(r.newClient(newWebsocketTransport(socket), userData, span, req))
+}
+
+func (r *room) newClient(conn transport, userData map[string]interface{}, span *trace.Span, req *http.Request) *client {
+	c := &client{
+		id:       nextClientID(),
+		conn:     conn,
+		send:     make(chan *message, r.messageBufferSize),
 		room:     r,
-		userData: objx.MustFromBase64(authCookie.Value),
+		userData: userData,
+		span:     span,
+		threads:  make(map[uint64]bool),
+	}
+	if since, err := strconv.ParseUint(req.URL.Query().Get("since"), 10, 64); err == nil {
+		c.since = since
 	}
-	r.join <- client
-	defer func() {
-		r.leave <- client
+	span.SetAttr("user", userData["name"])
+	span.SetAttr("transport", conn.Name())
+	return c
+}
+
+// serveはclientをroomに参加させ、接続が終わるまでメッセージを中継します。
+// 戻る前にwriteの終了を待つので、SSEのようにハンドラーが戻った後に書き込めない接続でも安全です。
+func (r *room) serve(client *client) {
+	r.conns.Add(1)
+	defer r.conns.Done()
+	r.touch(client)
+	// 参加する前に未読数を送り、ライブのメッセージより先に届くようにします
+	client.send <- r.unreadNotice(client)
+	select {
+	case r.join <- client:
+	case <-r.done:
+		client.conn.Close(true)
+		return
+	}
+	written := make(chan struct{})
+	go func() {
+		client.write()
+		close(written)
 	}()
-	go client.write()
 	client.read()
+	select {
+	case r.leave <- client:
+	case <-r.done:
+	}
+	<-written
+	r.touch(client)
+}
+
+// replayは再接続したクライアントに、切断中に送られたメッセージを送り直します。
+// runの中で呼ぶので、送り直しの途中にライブのメッセージが割り込むことはありません。
+// 送信バッファに入りきらない場合は新しいほうのメッセージだけを送ります。
+func (r *room) replay(c *client) {
+	missed := r.history.Since(r.name, c.since)
+	if room := cap(c.send) - len(c.send); len(missed) > room {
+		r.tracer.Trace(trace.Warn, "Replay truncated: ", len(missed)-room, " messages")
+		missed = missed[len(missed)-room:]
+	}
+	for _, msg := range missed {
+		c.send <- c.view(msg, r.summarize(msg))
+	}
+	r.tracer.Trace("Replayed ", len(missed), " messages")
+}
+
+// unreadNoticeはclientが最後に読んだ位置と、それより後のメッセージの数を知らせます
+func (r *room) unreadNotice(c *client) *message {
+	lastRead := r.receipts.LastRead(c.userID(), r.name)
+	return &message{
+		Type:     messageUnread,
+		Room:     r.name,
+		When:     time.Now(),
+		Unread:   len(r.history.Since(r.name, lastRead)),
+		LastRead: lastRead,
+	}
+}
+
+func (r *room) markRead(c *client, id uint64) {
+	// まだ存在しないIDまで進めると、その後のメッセージが既読扱いになってしまいます
+	if last := r.history.LastID(); id > last {
+		id = last
+	}
+	if err := r.receipts.MarkRead(c.userID(), r.name, id); err != nil {
+		r.tracer.Trace(trace.Error, "Failed to store read receipt: ", err)
+	}
+}
+
+// touchはclientのユーザーを最後に見かけた時刻を更新します
+func (r *room) touch(c *client) {
+	// プロフィールのないユーザーは記録するものがないので無視します
+	if err := userStore.Touch(c.userID(), time.Now()); err != nil && err != ErrUserNotFound {
+		r.tracer.Trace(trace.Error, "Failed to store last seen: ", err)
+	}
 }
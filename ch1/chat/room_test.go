@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	r := newRoom("main")
+	for i := 0; i < 5; i++ {
+		r.history.Append(&message{Room: "main", Message: "hi"})
+	}
+	c := &client{send: make(chan *message, 3), since: 1}
+	r.replay(c)
+	close(c.send)
+	var ids []uint64
+	for msg := range c.send {
+		ids = append(ids, msg.ID)
+	}
+	if len(ids) != 3 || ids[0] != 3 || ids[2] != 5 {
+		t.Errorf("replay should send the newest missed messages that fit, got %v", ids)
+	}
+}
+
+// stuckTransportは書き込みが戻らない接続です
+type stuckTransport struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (t *stuckTransport) Name() string { return "stuck" }
+
+func (t *stuckTransport) ReadMessage() (*message, error) {
+	<-t.closed
+	return nil, errTransportClosed
+}
+
+func (t *stuckTransport) WriteMessage(msg *message) error {
+	select {}
+}
+
+func (t *stuckTransport) Close(goingAway bool) error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
+
+func TestRoomCloseTimeout(t *testing.T) {
+	r := newRoom("main")
+	go r.run()
+	c := &client{conn: &stuckTransport{closed: make(chan struct{})}, send: make(chan *message, 4), room: r,
+		userData: map[string]interface{}{"userid": "abc", "name": "mat"}}
+	go r.serve(c)
+	waitFor(t, "the client to join", func() bool {
+		var n int
+		r.do(func() { n = len(r.clients) })
+		return n == 1
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := r.close(ctx, "bye"); err != context.DeadlineExceeded {
+		t.Errorf("close should give up when the context ends, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > testTimeout {
+		t.Errorf("close took %s", elapsed)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"log"
+	"net/http"
+
+	"github.com/stretchr/gomniauth"
+	"github.com/taitai9847/goblueprints/ch1/trace"
+)
+
+// chatServer はチャットアプリケーションのハンドラーと、それが使う状態をまとめたものです。
+// mainと結合テストの両方から同じ構成で組み立てられるようにしています。
+type chatServer struct {
+	cfg      *config
+	mux      *http.ServeMux
+	room     *room
+	history  *historyStore
+	receipts *receiptStore
+	users    UserStore
+}
+
+// newChatServer はcfgからハンドラーを組み立て、roomを開始します。
+// 認証プロバイダーやアバターの設定はパッケージ全体で共有されるので、ここで上書きします。
+func newChatServer(cfg *config, tracer trace.Tracer) (*chatServer, error) {
+	gomniauth.SetSecurityKey(cfg.SecurityKey)
+	cookieKey = []byte(cfg.SecurityKey)
+	if cfg.SecurityKey == "" {
+		// 鍵がないと誰でもクッキーを作れてしまうので、起動のたびに作った鍵で署名します。
+		// 再起動すると発行済みのクッキーは使えなくなります
+		cookieKey = make([]byte, 32)
+		if _, err := rand.Read(cookieKey); err != nil {
+			return nil, err
+		}
+		log.Println("security_keyが設定されていないため、一時的な鍵でクッキーに署名します")
+	}
+	gomniauth.WithProviders(cfg.authProviders()...)
+	avatars = cfg.avatarChain()
+	avatarDir = cfg.Paths.Avatars
+	if cfg.Dev {
+		templates = dirRenderer(cfg.Paths.Templates)
+	}
+
+	s := &chatServer{cfg: cfg, mux: http.NewServeMux()}
+	var err error
+	s.history = newHistoryStore(cfg.Buffers.History)
+	if cfg.Paths.History != "" {
+		if s.history, err = openHistory(cfg.Paths.History, cfg.Buffers.History); err != nil {
+			return nil, err
+		}
+	}
+	s.receipts = newReceiptStore()
+	if cfg.Paths.Receipts != "" {
+		if s.receipts, err = openReceipts(cfg.Paths.Receipts); err != nil {
+			s.history.Close()
+			return nil, err
+		}
+	}
+
+	users := newMemoryUserStore()
+	if cfg.Paths.Users != "" {
+		if users, err = openUserStore(cfg.Paths.Users); err != nil {
+			s.history.Close()
+			return nil, err
+		}
+	}
+	s.users = users
+	userStore = users
+
+	r := newRoom("main")
+	r.history = s.history
+	r.receipts = s.receipts
+	r.upgrader.ReadBufferSize = cfg.Buffers.Socket
+	r.upgrader.WriteBufferSize = cfg.Buffers.Socket
+	r.messageBufferSize = cfg.Buffers.Message
+	r.upgrader.CheckOrigin = checkOrigin(cfg.AllowedOrigins)
+	if tracer != nil {
+		r.tracer = tracer
+	}
+	s.room = r
+
+	mux := s.mux
+	mux.Handle("/chat", MustAuth(&templateHandler{filename: "chat.html"}))
+	mux.Handle("/login", &templateHandler{filename: "login.html", data: []pageData{loginProviders(cfg.Providers)}})
+	mux.HandleFunc("/auth/", loginHandler)
+	mux.Handle("/room", r)
+	mux.HandleFunc("/room/events", r.serveEvents)
+	mux.HandleFunc("/room/send", r.serveSend)
+	mux.HandleFunc("/logout", withCSRF(logoutHandler))
+	mux.Handle("/upload", MustAuth(&templateHandler{filename: "upload.html", data: []pageData{currentAvatar}}))
+	mux.Handle("/uploader", MustAuth(withCSRF(uploaderHandler)))
+	mux.Handle("/profile", MustAuth(profileHandler()))
+	mux.Handle("/api/users/", MustAuth(http.HandlerFunc(userAPIHandler)))
+	mux.Handle("/metrics", metrics)
+	mux.Handle("/api/history/search", MustAuth(historySearchHandler(s.history)))
+	mux.Handle("/api/history/export", MustAuth(historyExportHandler(s.history)))
+	mux.Handle("/api/history/thread", MustAuth(historyThreadHandler(s.history)))
+	if cfg.AdminKey != "" {
+		mux.Handle("/admin/", withAdminKey(cfg.AdminKey, newAdminAPI(r)))
+	}
+	mux.Handle("/avatars/",
+		http.StripPrefix("/avatars/",
+			http.FileServer(http.Dir(avatarDir))))
+
+	go r.run()
+	return s, nil
+}
+
+func (s *chatServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close はroomを停止して接続中のクライアントに知らせ、保存先を閉じます
+func (s *chatServer) Close(ctx context.Context, reason string) {
+	if err := s.room.close(ctx, reason); err != nil {
+		log.Println("切断を待たずにroomを閉じました:", err)
+	}
+	s.history.Close()
+	if err := s.receipts.Close(); err != nil {
+		log.Println("既読位置の保存に失敗しました:", err)
+	}
+	if err := s.users.Close(); err != nil {
+		log.Println("プロフィールの保存に失敗しました:", err)
+	}
+}
+
+// profileHandlerはGETでプロフィール画面を表示し、POSTで更新します
+func profileHandler() http.Handler {
+	page := &templateHandler{filename: "profile.html", data: []pageData{profileData}}
+	update := withCSRF(profileUpdateHandler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			update(w, r)
+			return
+		}
+		page.ServeHTTP(w, r)
+	})
+}
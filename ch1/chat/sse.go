@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/taitai9847/goblueprints/ch1/trace"
+)
+
+// sseKeepAlive はプロキシに接続を切られないよう、SSEでコメントを送る間隔です
+var sseKeepAlive = 15 * time.Second
+
+var errTransportClosed = errors.New("chat: 接続は閉じられています")
+
+// sseTransport はServer-Sent Eventsでメッセージを送り、
+// HTTPのPOSTでメッセージを受け取るtransportです。websocketが使えない環境のためのものです。
+type sseTransport struct {
+	id     string
+	userID string
+	w      http.ResponseWriter
+	flush  http.Flusher
+	// incomingはPOSTで届いたメッセージ
+	incoming chan *message
+	// goneはクライアントのリクエストが終わったときに閉じられます
+	gone <-chan struct{}
+	// closedはサーバー側から接続を閉じたときに閉じられます
+	closed chan struct{}
+	once   sync.Once
+	mu     sync.Mutex
+}
+
+func (t *sseTransport) Name() string { return "sse" }
+
+func (t *sseTransport) ReadMessage() (*message, error) {
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-t.incoming:
+			return msg, nil
+		case <-ticker.C:
+			if err := t.writeRaw(": keep-alive\n\n"); err != nil {
+				return nil, err
+			}
+		case <-t.gone:
+			return nil, errTransportClosed
+		case <-t.closed:
+			return nil, errTransportClosed
+		}
+	}
+}
+
+func (t *sseTransport) WriteMessage(msg *message) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return t.writeRaw(fmt.Sprintf("id: %d\ndata: %s\n\n", msg.ID, b))
+}
+
+func (t *sseTransport) writeEvent(event, data string) error {
+	return t.writeRaw(fmt.Sprintf("event: %s\ndata: %s\n\n", event, data))
+}
+
+// writeDeadliner はGo 1.20以降のResponseWriterが持つメソッドです
+type writeDeadliner interface {
+	SetWriteDeadline(deadline time.Time) error
+}
+
+func (t *sseTransport) writeRaw(s string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	select {
+	case <-t.closed:
+		return errTransportClosed
+	default:
+	}
+	// websocketと同じくwriteWaitより長く書き込みを待ちません
+	if d, ok := t.w.(writeDeadliner); ok {
+		d.SetWriteDeadline(time.Now().Add(writeWait))
+		defer d.SetWriteDeadline(time.Time{})
+	}
+	if _, err := fmt.Fprint(t.w, s); err != nil {
+		return err
+	}
+	t.flush.Flush()
+	return nil
+}
+
+func (t *sseTransport) Close(goingAway bool) error {
+	t.once.Do(func() {
+		if goingAway {
+			t.writeEvent("close", "going away")
+		}
+		t.mu.Lock()
+		close(t.closed)
+		t.mu.Unlock()
+	})
+	return nil
+}
+
+// deliver はPOSTで届いたメッセージを接続に渡します
+func (t *sseTransport) deliver(msg *message) error {
+	select {
+	case t.incoming <- msg:
+		return nil
+	case <-t.closed:
+		return errTransportClosed
+	case <-t.gone:
+		return errTransportClosed
+	}
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// serveEvents は GET /room/events でSSEの接続を開き、roomに参加します。
+// 最初に "session" イベントでセッションIDを送るので、
+// クライアントはそれを付けて POST /room/send?session=<id> でメッセージを送ります。
+func (r *room) serveEvents(w http.ResponseWriter, req *http.Request) {
+	_, span := trace.StartSpan(req.Context(), "room.session")
+	defer span.Finish()
+	userData, err := authData(req)
+	if err != nil {
+		http.Error(w, "クッキーの取得に失敗しました", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "SSEに対応していません", http.StatusInternalServerError)
+		return
+	}
+	t := &sseTransport{
+		id:       newSessionID(),
+		userID:   userData.Get("userid").Str(),
+		w:        w,
+		flush:    flusher,
+		incoming: make(chan *message),
+		gone:     req.Context().Done(),
+		closed:   make(chan struct{}),
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := t.writeEvent("session", t.id); err != nil {
+		return
+	}
+
+	r.sessionsMu.Lock()
+	r.sessions[t.id] = t
+	r.sessionsMu.Unlock()
+	defer func() {
+		r.sessionsMu.Lock()
+		delete(r.sessions, t.id)
+		r.sessionsMu.Unlock()
+	}()
+
+	r.serve(r.newClient(t, userData, span, req))
+}
+
+// serveSend は POST /room/send?session=<id> でSSEのクライアントからメッセージを受け取ります。
+// 本文はwebsocketで送るものと同じJSONです。
+func (r *room) serveSend(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		respondHTTPErr(w, req, http.StatusMethodNotAllowed)
+		return
+	}
+	r.sessionsMu.Lock()
+	t, ok := r.sessions[req.URL.Query().Get("session")]
+	r.sessionsMu.Unlock()
+	// セッションIDは接続したユーザーにしか分からないので、CSRFトークンの代わりになります
+	if !ok || t.userID != currentUserID(req) {
+		respondHTTPErr(w, req, http.StatusNotFound)
+		return
+	}
+	var msg message
+	if err := decodeBody(req, &msg); err != nil {
+		respondErr(w, req, http.StatusBadRequest, "メッセージを読み込めません: ", err)
+		return
+	}
+	if err := t.deliver(&msg); err != nil {
+		respondHTTPErr(w, req, http.StatusGone)
+		return
+	}
+	respond(w, req, http.StatusAccepted, nil)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseConnはテスト用のSSE接続です
+type sseConn struct {
+	t       *testing.T
+	user    *testUser
+	session string
+	events  chan sseEvent
+}
+
+type sseEvent struct {
+	name, data string
+}
+
+// dialSSEはユーザーとして/room/eventsに接続し、セッションIDと未読の通知を読み込みます
+func (u *testUser) dialSSE(t *testing.T, query string) *sseConn {
+	t.Helper()
+	path := "/room/events"
+	if query != "" {
+		path += "?" + query
+	}
+	req, _ := http.NewRequest("GET", u.ts.URL+path, nil)
+	// ストリームを読み続けるので、タイムアウトのないクライアントを使います
+	client := &http.Client{Jar: u.client.Jar}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %s", path, err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET %s: unexpected response %d %s", path, res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	c := &sseConn{t: t, user: u, events: make(chan sseEvent, 16)}
+	go func() {
+		defer close(c.events)
+		s := bufio.NewScanner(res.Body)
+		var ev sseEvent
+		for s.Scan() {
+			line := s.Text()
+			switch {
+			case line == "":
+				if ev.data != "" {
+					c.events <- ev
+				}
+				ev = sseEvent{}
+			case strings.HasPrefix(line, "event: "):
+				ev.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	ev := c.next()
+	if ev.name != "session" || ev.data == "" {
+		t.Fatalf("the first event should be the session, got %+v", ev)
+	}
+	c.session = ev.data
+	if msg := c.read(); msg.Type != messageUnread {
+		t.Fatalf("the first message should be the unread notice, got %+v", msg)
+	}
+	return c
+}
+
+func (c *sseConn) next() sseEvent {
+	c.t.Helper()
+	select {
+	case ev, ok := <-c.events:
+		if !ok {
+			c.t.Fatal("event stream closed")
+		}
+		return ev
+	case <-time.After(testTimeout):
+		c.t.Fatal("timed out waiting for an event")
+	}
+	return sseEvent{}
+}
+
+func (c *sseConn) read() *message {
+	c.t.Helper()
+	ev := c.next()
+	var msg message
+	if err := json.Unmarshal([]byte(ev.data), &msg); err != nil {
+		c.t.Fatalf("read: %s (%q)", err, ev.data)
+	}
+	return &msg
+}
+
+// postはセッションにメッセージを送り、ステータスコードを返します
+func (c *sseConn) post(u *testUser, session, body string) int {
+	c.t.Helper()
+	res, err := u.client.Post(u.ts.URL+"/room/send?session="+session, "application/json", strings.NewReader(body))
+	if err != nil {
+		c.t.Fatalf("POST /room/send: %s", err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func (c *sseConn) send(text string) {
+	c.t.Helper()
+	b, _ := json.Marshal(map[string]string{"Message": text})
+	if code := c.post(c.user, c.session, string(b)); code != http.StatusAccepted {
+		c.t.Fatalf("send: unexpected status %d", code)
+	}
+}
+
+func TestIntegrationSSE(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.login(t, "alice").dial(t, "")
+	bob := ts.login(t, "bob")
+	events := bob.dialSSE(t, "")
+	waitFor(t, "both clients to join", func() bool { return ts.clientCount(t) == 2 })
+
+	// websocketとSSEのクライアントが同じroomでやりとりできます
+	alice.send("hello from websocket")
+	if msg := events.read(); msg.Name != "alice" || msg.Message != "hello from websocket" {
+		t.Errorf("sse client got %+v", msg)
+	}
+	alice.read()
+	events.send("hello from sse")
+	for _, msg := range []*message{alice.read(), events.read()} {
+		if msg.Name != "bob" || msg.Message != "hello from sse" || msg.ID == 0 {
+			t.Errorf("unexpected broadcast: %+v", msg)
+		}
+	}
+
+	// 他のユーザーのセッションには送れません
+	carol := ts.login(t, "carol")
+	if code := events.post(carol, events.session, `{"Message":"spoof"}`); code != http.StatusNotFound {
+		t.Errorf("posting to another user's session should be 404, got %d", code)
+	}
+	if code := events.post(bob, "unknown", `{"Message":"x"}`); code != http.StatusNotFound {
+		t.Errorf("posting to an unknown session should be 404, got %d", code)
+	}
+	if code := events.post(bob, events.session, `not json`); code != http.StatusBadRequest {
+		t.Errorf("posting invalid json should be 400, got %d", code)
+	}
+}
+
+func TestIntegrationSSEShutdown(t *testing.T) {
+	ts := newTestServer(t)
+	events := ts.login(t, "alice").dialSSE(t, "")
+	waitFor(t, "alice to join", func() bool { return ts.clientCount(t) == 1 })
+	ts.chat.Close(context.Background(), "bye")
+	if msg := events.read(); msg.Type != messageSystem || msg.Message != "bye" {
+		t.Errorf("expected the shutdown notice, got %+v", msg)
+	}
+	if ev := events.next(); ev.name != "close" {
+		t.Errorf("expected a close event, got %+v", ev)
+	}
+}
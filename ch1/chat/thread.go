@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// threadRootは返信先のidを、そのスレッドの最初のメッセージのIDに置き換えます。
+// 返信への返信も同じスレッドに入ります。このroomにないメッセージへの返信は0を返し、通常のメッセージになります。
+func (r *room) threadRoot(id uint64) uint64 {
+	parent, ok := r.history.Get(id)
+	if !ok || parent.Room != r.name {
+		return 0
+	}
+	if parent.ParentID != 0 {
+		return parent.ParentID
+	}
+	return parent.ID
+}
+
+// subscribeはclientがスレッドidの返信をそのまま受け取るかどうかを切り替えます
+func (r *room) subscribe(c *client, id uint64, on bool) {
+	if id == 0 {
+		return
+	}
+	r.do(func() {
+		if on {
+			c.threads[id] = true
+		} else {
+			delete(c.threads, id)
+		}
+	})
+}
+
+// summarizeは返信を購読していないクライアントのための要約を作ります。返信でなければnilを返します。
+// 要約には返信の数と最後の返信の時刻だけを入れ、返信の本文や送った人は含めません。
+// IDは既読の位置と再接続時の続きを決めるために残しています
+func (r *room) summarize(msg *message) *message {
+	if msg.ParentID == 0 {
+		return nil
+	}
+	return &message{
+		ID:       msg.ID,
+		Type:     messageThread,
+		ParentID: msg.ParentID,
+		Room:     msg.Room,
+		When:     msg.When,
+		Replies:  r.history.Replies(msg.ParentID),
+	}
+}
+
+// viewはclientに送るメッセージを選びます。購読していないスレッドの返信は要約になります
+func (c *client) view(msg, summary *message) *message {
+	if summary == nil || c.threads[msg.ParentID] {
+		return msg
+	}
+	return summary
+}
+
+// historyThreadHandler は GET /api/history/thread?id= でスレッドの最初のメッセージと返信を古い順に返します
+func historyThreadHandler(h *historyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			respondHTTPErr(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			respondErr(w, r, http.StatusBadRequest, "idが正しくありません")
+			return
+		}
+		root, ok := h.Get(id)
+		if !ok {
+			respondHTTPErr(w, r, http.StatusNotFound)
+			return
+		}
+		if root.ParentID != 0 {
+			if root, ok = h.Get(root.ParentID); !ok {
+				respondHTTPErr(w, r, http.StatusNotFound)
+				return
+			}
+		}
+		respond(w, r, http.StatusOK, append([]*message{root}, h.Thread(root.ID)...))
+	}
+}
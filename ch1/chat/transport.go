@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// transport はクライアントとの接続の種類を隠すためのインターフェースです。
+// websocketとSSE+POSTのどちらのクライアントも同じroomに参加できます。
+type transport interface {
+	// ReadMessage はクライアントから次のメッセージが届くまで待ちます
+	ReadMessage() (*message, error)
+	// WriteMessage はクライアントにメッセージを送ります
+	WriteMessage(msg *message) error
+	// Close は接続を閉じます。goingAwayはサーバーの停止による切断であることを表します。
+	// 何度呼び出しても構いません
+	Close(goingAway bool) error
+	// Name はトレースやメトリクスに使う接続の種類の名前です
+	Name() string
+}
+
+// writeWait は1回の書き込みを待つ時間です。読まないクライアントのせいで
+// 書き込みのgoroutineやサーバーの停止がいつまでも止まらないようにします
+var writeWait = 10 * time.Second
+
+type websocketTransport struct {
+	socket *websocket.Conn
+	once   sync.Once
+}
+
+func newWebsocketTransport(socket *websocket.Conn) *websocketTransport {
+	return &websocketTransport{socket: socket}
+}
+
+func (t *websocketTransport) Name() string { return "websocket" }
+
+func (t *websocketTransport) ReadMessage() (*message, error) {
+	var msg *message
+	if err := t.socket.ReadJSON(&msg); err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		msg = &message{}
+	}
+	return msg, nil
+}
+
+func (t *websocketTransport) WriteMessage(msg *message) error {
+	t.socket.SetWriteDeadline(time.Now().Add(writeWait))
+	return t.socket.WriteJSON(msg)
+}
+
+func (t *websocketTransport) Close(goingAway bool) error {
+	var err error
+	t.once.Do(func() {
+		code := websocket.CloseNormalClosure
+		if goingAway {
+			code = websocket.CloseGoingAway
+		}
+		t.socket.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(code, ""), time.Now().Add(writeWait))
+		err = t.socket.Close()
+	})
+	return err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebsocketWriteDeadline(t *testing.T) {
+	saved := writeWait
+	writeWait = 50 * time.Millisecond
+	defer func() { writeWait = saved }()
+
+	result := make(chan error, 1)
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		socket, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			result <- err
+			return
+		}
+		conn := newWebsocketTransport(socket)
+		// 読まないクライアントに送り続けると、バッファが一杯になった所で書き込みが期限切れになります
+		msg := &message{Message: strings.Repeat("x", 1<<20)}
+		for i := 0; i < 256 && err == nil; i++ {
+			err = conn.WriteMessage(msg)
+		}
+		conn.Close(false)
+		result <- err
+	}))
+	defer ts.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("writing to a client that never reads should time out")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("WriteMessage blocked past the write deadline")
+	}
+}
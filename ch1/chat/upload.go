@@ -4,26 +4,44 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
-	"path"
+	"path/filepath"
 )
 
+// uploaderHandlerはアップロードされた画像をログイン中のユーザーのアバターとして保存します。
+// ユーザーのIDは署名されたauthクッキーから取るので、他のユーザーのアバターは書き換えられません
 func uploaderHandler(w http.ResponseWriter, req *http.Request) {
-	userID := req.FormValue("userid")
+	userID := currentUserID(req)
+	if userID == "" {
+		metrics.uploads.inc("error")
+		http.Error(w, "ログインしてください", http.StatusUnauthorized)
+		return
+	}
 	file, header, err := req.FormFile("avatarFile")
 	if err != nil {
+		metrics.uploads.inc("error")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	data, err := ioutil.ReadAll(file)
 	if err != nil {
+		metrics.uploads.inc("error")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	filename := path.Join("avatars", userID+path.Ext(header.Filename))
+	dir := filepath.Clean(avatarDir)
+	filename := filepath.Join(dir, userID+filepath.Ext(header.Filename))
+	// avatarDirの外 (テンプレートのディレクトリなど) に書き込めないよう、直下のファイルだけを許します
+	if filepath.Dir(filename) != dir {
+		metrics.uploads.inc("error")
+		http.Error(w, "ファイル名が正しくありません", http.StatusBadRequest)
+		return
+	}
 	err = ioutil.WriteFile(filename, data, 0777)
 	if err != nil {
+		metrics.uploads.inc("error")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	metrics.uploads.inc("success")
 	io.WriteString(w, "Successful")
 }
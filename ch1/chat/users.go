@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stretchr/objx"
+)
+
+var ErrUserNotFound = errors.New("chat: ユーザーが見つかりません")
+
+// UserProfile は初回のログイン時に作られ、以降はユーザーが編集できるプロフィールです。
+// ChatUserを実装しているので、そのままアバターの取得に使えます。
+type UserProfile struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	// Avatar は使うアバターの種類です (filesystem, auth, gravatar)。空の場合は設定された順に探します
+	Avatar string `json:"avatar,omitempty"`
+	Status string `json:"status,omitempty"`
+	// ProviderAvatarURL は認証プロバイダーから取得したアバターのURLです
+	ProviderAvatarURL string    `json:"-"`
+	Created           time.Time `json:"created"`
+	LastSeen          time.Time `json:"last_seen"`
+}
+
+func (p *UserProfile) UniqueID() string {
+	return p.ID
+}
+
+func (p *UserProfile) AvatarURL() string {
+	return p.ProviderAvatarURL
+}
+
+// ResolveAvatarURL はプロフィールで選ばれたアバターのURLを返します。
+// 選ばれたアバターが見つからない場合はfallbackで探します。
+func (p *UserProfile) ResolveAvatarURL(fallback Avatar) (string, error) {
+	if avatar, ok := avatarSources[p.Avatar]; ok {
+		if url, err := avatar.GetAvatarURL(p); err == nil {
+			return url, nil
+		}
+	}
+	return fallback.GetAvatarURL(p)
+}
+
+// UserStore はユーザーのプロフィールを保存します
+type UserStore interface {
+	// Get はidのプロフィールを返します。なければErrUserNotFoundを返します
+	Get(id string) (*UserProfile, error)
+	// Put はプロフィールを作成または更新します
+	Put(p *UserProfile) error
+	// Touch は最後にユーザーを見かけた時刻を更新します。保存はまとめて行われることがあります
+	Touch(id string, t time.Time) error
+	// Close は保存待ちの更新を書き出します
+	Close() error
+}
+
+// jsonUserStore はプロフィールをメモリに保持し、ファイルを指定した場合はJSONとして保存するUserStoreです。
+// Touchは接続と切断のたびに呼ばれるので、userSaveDelayの間の更新をまとめて保存します。
+type jsonUserStore struct {
+	mu    sync.Mutex
+	path  string
+	users map[string]*UserProfile
+	// batchはTouchの保存をまとめます。裏での保存のエラーは次のTouchで返します
+	batch batchedSave
+}
+
+const userSaveDelay = 5 * time.Second
+
+func newMemoryUserStore() *jsonUserStore {
+	s := &jsonUserStore{users: map[string]*UserProfile{}}
+	s.batch = batchedSave{mu: &s.mu, delay: userSaveDelay, save: s.save}
+	return s
+}
+
+// openUserStore はpathに保存されたプロフィールを読み込みます。ファイルがなければ空から始めます
+func openUserStore(path string) (*jsonUserStore, error) {
+	s := newMemoryUserStore()
+	s.path = path
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored []*storedProfile
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return nil, err
+	}
+	for _, sp := range stored {
+		p := sp.UserProfile
+		p.ProviderAvatarURL = sp.ProviderAvatarURL
+		s.users[p.ID] = &p
+	}
+	return s, nil
+}
+
+// storedProfile はAPIでは返さない項目も含めてファイルに保存するための形です
+type storedProfile struct {
+	UserProfile
+	ProviderAvatarURL string `json:"provider_avatar_url,omitempty"`
+}
+
+func (s *jsonUserStore) Get(id string) (*UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	copied := *p
+	return &copied, nil
+}
+
+func (s *jsonUserStore) Put(p *UserProfile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *p
+	s.users[p.ID] = &copied
+	// 保存待ちのTouchもここで一緒に書き出されます
+	s.batch.cancel()
+	return s.save()
+}
+
+func (s *jsonUserStore) Touch(id string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	p.LastSeen = t
+	if s.path == "" {
+		return nil
+	}
+	return s.batch.schedule()
+}
+
+func (s *jsonUserStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.batch.close()
+}
+
+func (s *jsonUserStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	stored := make([]*storedProfile, 0, len(s.users))
+	for _, p := range s.users {
+		stored = append(stored, &storedProfile{UserProfile: *p, ProviderAvatarURL: p.ProviderAvatarURL})
+	}
+	b, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b)
+}
+
+var userStore UserStore = newMemoryUserStore()
+
+// loginUser はログインしたユーザーのプロフィールを返します。初めてのユーザーなら作成します
+func loginUser(id, name, providerAvatarURL string, now time.Time) (*UserProfile, error) {
+	p, err := userStore.Get(id)
+	if err == ErrUserNotFound {
+		p = &UserProfile{ID: id, DisplayName: name, Created: now}
+	} else if err != nil {
+		return nil, err
+	}
+	p.ProviderAvatarURL = providerAvatarURL
+	p.LastSeen = now
+	if err := userStore.Put(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// cookieKey はauthクッキーの署名に使う鍵です。newChatServerで設定されます
+var cookieKey []byte
+
+var ErrBadAuthCookie = errors.New("chat: authクッキーの署名が正しくありません")
+
+// setAuthCookie はプロフィールの内容でauthクッキーを発行します。
+// クッキーの内容はHMACで署名するので、利用者がuseridを書き換えることはできません
+func setAuthCookie(w http.ResponseWriter, p *UserProfile) error {
+	avatarURL, err := p.ResolveAvatarURL(avatars)
+	if err != nil {
+		return err
+	}
+	value := objx.New(map[string]interface{}{
+		"userid":     p.ID,
+		"name":       p.DisplayName,
+		"avatar_url": avatarURL,
+	}).MustBase64()
+	http.SetCookie(w, &http.Cookie{
+		Name:  "auth",
+		Value: value + "." + cookieSignature(value),
+		Path:  "/"})
+	return nil
+}
+
+func cookieSignature(value string) string {
+	mac := hmac.New(sha256.New, cookieKey)
+	io.WriteString(mac, value)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseAuthCookie は署名を確かめてからauthクッキーの内容を取り出します
+func parseAuthCookie(cookie string) (objx.Map, error) {
+	i := strings.LastIndex(cookie, ".")
+	if i < 0 {
+		return nil, ErrBadAuthCookie
+	}
+	value, sig := cookie[:i], cookie[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(cookieSignature(value))) {
+		return nil, ErrBadAuthCookie
+	}
+	return objx.FromBase64(value)
+}
+
+// authData はリクエストのauthクッキーからユーザーの情報を取り出します
+func authData(r *http.Request) (objx.Map, error) {
+	c, err := r.Cookie("auth")
+	if err != nil {
+		return nil, err
+	}
+	return parseAuthCookie(c.Value)
+}
+
+// currentUserID はauthクッキーからユーザーのIDを取り出します
+func currentUserID(r *http.Request) string {
+	data, err := authData(r)
+	if err != nil {
+		return ""
+	}
+	return data.Get("userid").Str()
+}
+
+// profileData はプロフィール画面に現在のプロフィールと選べるアバターを渡します
+func profileData(r *http.Request, data map[string]interface{}) {
+	if p, err := userStore.Get(currentUserID(r)); err == nil {
+		data["Profile"] = p
+	}
+	data["AvatarChoices"] = []string{"", "filesystem", "auth", "gravatar"}
+}
+
+// profileUpdateHandler はプロフィール画面のフォームを受け取って保存し、クッキーを発行し直します
+func profileUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := userStore.Get(currentUserID(r))
+	if err != nil {
+		http.Error(w, "プロフィールが見つかりません", http.StatusNotFound)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("display_name"))
+	if name == "" {
+		http.Error(w, "表示名を入力してください", http.StatusBadRequest)
+		return
+	}
+	avatar := r.FormValue("avatar")
+	if _, ok := avatarSources[avatar]; avatar != "" && !ok {
+		http.Error(w, "アバターの種類が正しくありません", http.StatusBadRequest)
+		return
+	}
+	p.DisplayName = name
+	p.Status = strings.TrimSpace(r.FormValue("status"))
+	p.Avatar = avatar
+	if err := userStore.Put(p); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := setAuthCookie(w, p); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/profile")
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// userAPIHandler は GET /api/users/{id} でユーザーの公開プロフィールを返します
+func userAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondHTTPErr(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/users/"), "/")
+	if id == "" || strings.Contains(id, "/") {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	p, err := userStore.Get(id)
+	if err == ErrUserNotFound {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		respondErr(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	avatarURL, _ := p.ResolveAvatarURL(avatars)
+	respond(w, r, http.StatusOK, map[string]interface{}{
+		"id":           p.ID,
+		"display_name": p.DisplayName,
+		"status":       p.Status,
+		"avatar_url":   avatarURL,
+		"created":      p.Created,
+		"last_seen":    p.LastSeen,
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/objx"
+)
+
+func TestUserStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	s, err := openUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	s.Put(&UserProfile{ID: "abc", DisplayName: "mat", ProviderAvatarURL: "http://example.com/a.png", Created: now})
+	s.Touch("abc", now.Add(time.Hour))
+	if err := s.Touch("nobody", now); err != ErrUserNotFound {
+		t.Errorf("Touch should return ErrUserNotFound for unknown users, got %v", err)
+	}
+	if reloaded, _ := openUserStore(path); !reloaded.users["abc"].LastSeen.IsZero() {
+		t.Error("Touch should batch saves instead of rewriting the file every time")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = openUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.Get("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.DisplayName != "mat" || !p.LastSeen.Equal(now.Add(time.Hour)) || p.AvatarURL() != "http://example.com/a.png" {
+		t.Errorf("openUserStore should reload saved profiles, got %+v", p)
+	}
+}
+
+func TestResolveAvatarURL(t *testing.T) {
+	p := &UserProfile{ID: "abc", Avatar: "gravatar", ProviderAvatarURL: "http://example.com/a.png"}
+	url, _ := p.ResolveAvatarURL(UseAuthAvatar)
+	if url != "//www.gravatar.com/avatar/abc" {
+		t.Errorf("the chosen avatar should win, got %s", url)
+	}
+	p.Avatar = ""
+	url, _ = p.ResolveAvatarURL(UseAuthAvatar)
+	if url != "http://example.com/a.png" {
+		t.Errorf("without a choice the fallback should be used, got %s", url)
+	}
+}
+
+func TestAuthCookieSignature(t *testing.T) {
+	saved := cookieKey
+	defer func() { cookieKey = saved }()
+	cookieKey = []byte("test-key")
+
+	w := httptest.NewRecorder()
+	if err := setAuthCookie(w, &UserProfile{ID: "abc", DisplayName: "mat", Avatar: "gravatar"}); err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Result().Cookies()[0].Value
+	data, err := parseAuthCookie(cookie)
+	if err != nil || data.Get("userid").Str() != "abc" {
+		t.Errorf("parseAuthCookie should accept cookies we issued: %v %v", data, err)
+	}
+
+	forged := objx.New(map[string]interface{}{"userid": "admin"}).MustBase64()
+	for _, c := range []string{forged, forged + cookie[strings.LastIndex(cookie, "."):]} {
+		if _, err := parseAuthCookie(c); err != ErrBadAuthCookie {
+			t.Errorf("parseAuthCookie should reject forged cookie %q, got %v", c, err)
+		}
+	}
+}
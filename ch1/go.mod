@@ -3,10 +3,12 @@ module github.com/taitai9847/goblueprints/ch1
 go 1.17
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/gorilla/websocket v1.4.2
 	github.com/joho/godotenv v1.4.0
 	github.com/stretchr/gomniauth v0.0.0-20170717123514-4b6c822be2eb
 	github.com/stretchr/objx v0.3.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -21,5 +23,4 @@ require (
 	github.com/ugorji/go/codec v1.2.6 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
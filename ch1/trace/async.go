@@ -0,0 +1,70 @@
+package trace
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// AsyncTracer はメッセージをバッファに積み、別のgoroutineでnextに書き出します。
+// バッファが一杯のときはメッセージを破棄するので、呼び出し側がブロックされることはありません。
+type AsyncTracer struct {
+	next    Tracer
+	queue   chan []interface{}
+	done    chan struct{}
+	once    sync.Once
+	mu      sync.RWMutex
+	closed  bool
+	dropped uint64
+}
+
+// Async はバッファサイズsizeのAsyncTracerを作成します
+func Async(next Tracer, size int) *AsyncTracer {
+	if size < 1 {
+		size = 1
+	}
+	t := &AsyncTracer{
+		next:  next,
+		queue: make(chan []interface{}, size),
+		done:  make(chan struct{}),
+	}
+	go t.loop()
+	return t
+}
+
+func (t *AsyncTracer) loop() {
+	defer close(t.done)
+	for a := range t.queue {
+		t.next.Trace(a...)
+	}
+}
+
+func (t *AsyncTracer) Trace(a ...interface{}) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		atomic.AddUint64(&t.dropped, 1)
+		return
+	}
+	select {
+	case t.queue <- a:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// Dropped はバッファ溢れやClose後に破棄されたメッセージの数を返します
+func (t *AsyncTracer) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// Close は新しいメッセージの受け付けを止め、バッファに残っている分を書き出してから戻ります
+func (t *AsyncTracer) Close() error {
+	t.once.Do(func() {
+		t.mu.Lock()
+		t.closed = true
+		close(t.queue)
+		t.mu.Unlock()
+	})
+	<-t.done
+	return nil
+}
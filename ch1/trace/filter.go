@@ -0,0 +1,82 @@
+package trace
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Level はトレースの重要度です。Traceの第1引数にLevelを渡すと、
+// そのメッセージのレベルとして扱われます。省略した場合はInfoになります。
+// Newで作ったTracerはレベルを "[ERROR] " のように本文と区切って書き出します。
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelStrings = map[Level]string{
+	Debug: "DEBUG",
+	Info:  "INFO",
+	Warn:  "WARN",
+	Error: "ERROR",
+}
+
+func (l Level) String() string {
+	if s, ok := levelStrings[l]; ok {
+		return s
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// LevelOf はTraceに渡された引数からレベルを取り出します
+func LevelOf(a ...interface{}) Level {
+	if l, _, ok := splitLevel(a); ok {
+		return l
+	}
+	return Info
+}
+
+// splitLevel は先頭のLevelとメッセージ本文を分けます
+func splitLevel(a []interface{}) (Level, []interface{}, bool) {
+	if len(a) > 0 {
+		if l, ok := a[0].(Level); ok {
+			return l, a[1:], true
+		}
+	}
+	return Info, a, false
+}
+
+// Filter はkeepがtrueを返したメッセージだけをnextに渡すTracerを返します
+func Filter(next Tracer, keep func(a ...interface{}) bool) Tracer {
+	return &filterTracer{next: next, keep: keep}
+}
+
+// MinLevel はmin以上のレベルのメッセージだけをnextに渡します
+func MinLevel(next Tracer, min Level) Tracer {
+	return Filter(next, func(a ...interface{}) bool {
+		return LevelOf(a...) >= min
+	})
+}
+
+// Prefix はprefixで始まるメッセージだけをnextに渡します。
+// レベル指定がある場合はそれを除いたメッセージ本文で判定します。
+func Prefix(next Tracer, prefix string) Tracer {
+	return Filter(next, func(a ...interface{}) bool {
+		_, msg, _ := splitLevel(a)
+		return strings.HasPrefix(fmt.Sprint(msg...), prefix)
+	})
+}
+
+type filterTracer struct {
+	next Tracer
+	keep func(a ...interface{}) bool
+}
+
+func (f *filterTracer) Trace(a ...interface{}) {
+	if f.keep(a...) {
+		f.next.Trace(a...)
+	}
+}
@@ -0,0 +1,22 @@
+package trace
+
+// Multi は渡されたすべてのTracerに同じ内容を書き出すTracerを返します
+func Multi(tracers ...Tracer) Tracer {
+	ts := make([]Tracer, 0, len(tracers))
+	for _, t := range tracers {
+		if t != nil {
+			ts = append(ts, t)
+		}
+	}
+	return &multiTracer{tracers: ts}
+}
+
+type multiTracer struct {
+	tracers []Tracer
+}
+
+func (m *multiTracer) Trace(a ...interface{}) {
+	for _, t := range m.tracers {
+		t.Trace(a...)
+	}
+}
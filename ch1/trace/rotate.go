@@ -0,0 +1,156 @@
+package trace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotatingFile はサイズまたは経過時間でファイルを切り替えるio.WriteCloserです。
+// 切り替え時には現在のファイルを "<name>.<timestamp>" にリネームし、
+// 新しいファイルを同じ名前で作成します。
+type RotatingFile struct {
+	// Filename は書き込み先のファイルパスです
+	Filename string
+	// MaxSize はファイルの最大バイト数です。0の場合はサイズで切り替えません
+	MaxSize int64
+	// MaxAge はファイルを開いてから切り替えるまでの時間です。0の場合は時間で切り替えません
+	MaxAge time.Duration
+	// MaxBackups は残しておく古いファイルの数です。0の場合はすべて残します
+	MaxBackups int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	now    func() time.Time
+}
+
+const rotateTimeFormat = "20060102T150405.000000000"
+
+// NewRotatingFile はfilenameに書き込むRotatingFileを作成します
+func NewRotatingFile(filename string, maxSize int64, maxAge time.Duration) *RotatingFile {
+	return &RotatingFile{Filename: filename, MaxSize: maxSize, MaxAge: maxAge}
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.shouldRotate(int64(len(p))) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate は条件に関わらず現在のファイルを切り替えます
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return f.open()
+	}
+	return f.rotate()
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) clock() time.Time {
+	if f.now != nil {
+		return f.now()
+	}
+	return time.Now()
+}
+
+func (f *RotatingFile) shouldRotate(n int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.MaxSize > 0 && f.size+n > f.MaxSize {
+		return true
+	}
+	if f.MaxAge > 0 && f.clock().Sub(f.opened) >= f.MaxAge {
+		return true
+	}
+	return false
+}
+
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.Filename), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = f.clock()
+	return nil
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	backup := fmt.Sprintf("%s.%s", f.Filename, f.clock().Format(rotateTimeFormat))
+	if err := os.Rename(f.Filename, backup); err != nil {
+		return err
+	}
+	if err := f.prune(); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *RotatingFile) prune() error {
+	if f.MaxBackups <= 0 {
+		return nil
+	}
+	// タイムスタンプの書式上、ファイル名の昇順がそのまま古い順になります
+	matches, err := filepath.Glob(f.Filename + ".*")
+	if err != nil {
+		return err
+	}
+	// 同じ名前で始まる無関係なファイルを消さないよう、切り替え時に付けた名前だけを対象にします
+	var backups []string
+	for _, m := range matches {
+		suffix := strings.TrimPrefix(m, f.Filename+".")
+		if _, err := time.Parse(rotateTimeFormat, suffix); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	for len(backups) > f.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
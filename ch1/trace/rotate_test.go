@@ -0,0 +1,65 @@
+package trace
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileSize(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "chat.log")
+	f := NewRotatingFile(name, 10, 0)
+	defer f.Close()
+	tracer := New(f)
+	tracer.Trace("12345678")
+	tracer.Trace("abcdefgh")
+	backups, _ := filepath.Glob(name + ".*")
+	if len(backups) != 1 {
+		t.Fatalf("RotatingFile should rotate once when MaxSize is exceeded, got %d backups", len(backups))
+	}
+	b, _ := ioutil.ReadFile(name)
+	if string(b) != "abcdefgh\n" {
+		t.Errorf("RotatingFile wrote '%s' to the current file", b)
+	}
+}
+
+func TestRotatingFileAge(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "chat.log")
+	now := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	f := NewRotatingFile(name, 0, time.Hour)
+	f.MaxBackups = 1
+	f.now = func() time.Time { return now }
+	defer f.Close()
+	for i := 0; i < 3; i++ {
+		f.Write([]byte("line\n"))
+		now = now.Add(2 * time.Hour)
+	}
+	backups, _ := filepath.Glob(name + ".*")
+	if len(backups) != 1 {
+		t.Errorf("RotatingFile should keep MaxBackups old files, got %d", len(backups))
+	}
+}
+
+func TestRotatingFilePruneKeepsUnrelated(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "chat.log")
+	other := name + ".0"
+	if err := ioutil.WriteFile(other, []byte("keep\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	f := NewRotatingFile(name, 0, time.Hour)
+	f.MaxBackups = 1
+	f.now = func() time.Time { return now }
+	defer f.Close()
+	for i := 0; i < 3; i++ {
+		f.Write([]byte("line\n"))
+		now = now.Add(2 * time.Hour)
+	}
+	if _, err := ioutil.ReadFile(other); err != nil {
+		t.Errorf("RotatingFile should not prune files it did not rotate: %v", err)
+	}
+}
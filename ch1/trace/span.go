@@ -0,0 +1,189 @@
+package trace
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TraceIDHeader はリクエストをまたいでトレースIDを引き継ぐためのHTTPヘッダーです
+const TraceIDHeader = "X-Trace-Id"
+
+// Span は処理のひとまとまりの開始・終了を記録します。
+// 親子関係はcontext.Contextを通して引き継がれます。
+type Span struct {
+	TraceID  string                 `json:"trace_id"`
+	SpanID   string                 `json:"span_id"`
+	ParentID string                 `json:"parent_id,omitempty"`
+	Name     string                 `json:"name"`
+	Start    time.Time              `json:"start"`
+	End      time.Time              `json:"end"`
+	Duration time.Duration          `json:"duration_ns"`
+	Attrs    map[string]interface{} `json:"attrs,omitempty"`
+
+	mu       sync.Mutex
+	exporter Exporter
+	finished bool
+}
+
+// Exporter は終了したSpanを受け取ります
+type Exporter interface {
+	ExportSpan(*Span)
+}
+
+type contextKey int
+
+const (
+	spanKey contextKey = iota
+	exporterKey
+)
+
+// WithExporter はctxから始まるSpanの書き出し先をeに設定します
+func WithExporter(ctx context.Context, e Exporter) context.Context {
+	return context.WithValue(ctx, exporterKey, e)
+}
+
+// SpanFromContext はctxに入っている現在のSpanを返します。なければnilです
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey).(*Span)
+	return s
+}
+
+// StartSpan は新しいSpanを開始し、それを子Spanの親とするcontextを返します。
+// ctxにSpanがあればその子になり、なければ新しいトレースを始めます。
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	s := &Span{
+		SpanID: newID(8),
+		Name:   name,
+		Start:  time.Now(),
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.TraceID = parent.TraceID
+		s.ParentID = parent.SpanID
+		s.exporter = parent.exporter
+	} else {
+		s.TraceID = newID(16)
+	}
+	if e, ok := ctx.Value(exporterKey).(Exporter); ok && e != nil {
+		s.exporter = e
+	}
+	return context.WithValue(ctx, spanKey, s), s
+}
+
+// SetAttr はSpanに属性を追加します
+func (s *Span) SetAttr(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Attrs == nil {
+		s.Attrs = map[string]interface{}{}
+	}
+	s.Attrs[key] = value
+}
+
+// Finish はSpanを終了して書き出します。2回目以降の呼び出しは無視されます
+func (s *Span) Finish() {
+	s.mu.Lock()
+	if s.finished {
+		s.mu.Unlock()
+		return
+	}
+	s.finished = true
+	s.End = time.Now()
+	s.Duration = s.End.Sub(s.Start)
+	e := s.exporter
+	s.mu.Unlock()
+	if e != nil {
+		e.ExportSpan(s)
+	}
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewJSONExporter は終了したSpanを1行1件のJSONとしてwに書き出すExporterを返します
+func NewJSONExporter(w io.Writer) Exporter {
+	return &jsonExporter{enc: json.NewEncoder(w)}
+}
+
+type jsonExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (j *jsonExporter) ExportSpan(s *Span) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.enc.Encode(s)
+}
+
+// Middleware はリクエストごとにnameという名前のSpanを開始するhttp.Handlerを返します。
+// リクエストにTraceIDHeaderがあれば、そのトレースIDを引き継ぎます。
+func Middleware(name string, e Exporter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if e != nil {
+			ctx = WithExporter(ctx, e)
+		}
+		ctx, span := StartSpan(ctx, name)
+		if id := r.Header.Get(TraceIDHeader); id != "" {
+			span.TraceID = id
+		}
+		span.SetAttr("method", r.Method)
+		span.SetAttr("path", r.URL.Path)
+		w.Header().Set(TraceIDHeader, span.TraceID)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			span.SetAttr("status", sw.status)
+			span.Finish()
+		}()
+		next.ServeHTTP(sw, r.WithContext(ctx))
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack はwebsocketへのアップグレードのために元のResponseWriterに委譲します
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("trace: ResponseWriter does not implement http.Hijacker")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// SetWriteDeadline は元のResponseWriterが対応していれば委譲します
+func (w *statusWriter) SetWriteDeadline(deadline time.Time) error {
+	d, ok := w.ResponseWriter.(interface{ SetWriteDeadline(time.Time) error })
+	if !ok {
+		return errors.New("trace: ResponseWriter does not support write deadlines")
+	}
+	return d.SetWriteDeadline(deadline)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
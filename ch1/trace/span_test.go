@@ -0,0 +1,59 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSpanParentChild(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := WithExporter(context.Background(), NewJSONExporter(&buf))
+	ctx, parent := StartSpan(ctx, "parent")
+	_, child := StartSpan(ctx, "child")
+	child.SetAttr("user", "mat")
+	child.Finish()
+	parent.Finish()
+	parent.Finish()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 exported spans, got %d: %s", len(lines), buf.String())
+	}
+	var got Span
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "child" || got.ParentID != parent.SpanID || got.TraceID != parent.TraceID {
+		t.Errorf("child span was not linked to its parent: name=%q parent=%q trace=%q", got.Name, got.ParentID, got.TraceID)
+	}
+	if got.Attrs["user"] != "mat" {
+		t.Errorf("child span lost its attributes: %+v", got.Attrs)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	var inner *Span
+	h := Middleware("http", NewJSONExporter(&buf), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inner = SpanFromContext(r.Context())
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	req := httptest.NewRequest("GET", "/chat", nil)
+	req.Header.Set(TraceIDHeader, "abc")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if inner == nil || inner.TraceID != "abc" {
+		t.Fatalf("Middleware should propagate the incoming trace id: %+v", inner)
+	}
+	if w.Header().Get(TraceIDHeader) != "abc" {
+		t.Error("Middleware should echo the trace id header")
+	}
+	if !strings.Contains(buf.String(), `"status":418`) {
+		t.Errorf("Middleware should record the response status: %s", buf.String())
+	}
+}
@@ -18,6 +18,10 @@ type tracer struct {
 }
 
 func (t *tracer) Trace(a ...interface{}) {
+	if l, msg, ok := splitLevel(a); ok {
+		fmt.Fprintf(t.out, "[%s] ", l)
+		a = msg
+	}
 	fmt.Fprint(t.out, a...)
 	fmt.Fprintln(t.out)
 }
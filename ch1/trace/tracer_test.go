@@ -22,3 +22,73 @@ func TestOff(t *testing.T) {
 	silentTracer := Off()
 	silentTracer.Trace("something")
 }
+
+func TestMulti(t *testing.T) {
+	var buf1, buf2 bytes.Buffer
+	tracer := Multi(New(&buf1), nil, New(&buf2))
+	tracer.Trace("fan out")
+	if buf1.String() != "fan out\n" || buf2.String() != "fan out\n" {
+		t.Errorf("Multi should write to every tracer: '%s' '%s'", buf1.String(), buf2.String())
+	}
+}
+
+func TestMinLevel(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := MinLevel(New(&buf), Warn)
+	tracer.Trace(Debug, "debug")
+	tracer.Trace("info")
+	tracer.Trace(Error, "error")
+	if buf.String() != "[ERROR] error\n" {
+		t.Errorf("MinLevel should only pass Warn and above: '%s'", buf.String())
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := Prefix(New(&buf), "Message")
+	tracer.Trace("New client joined")
+	tracer.Trace("Message received: ", "hi")
+	tracer.Trace(Warn, "Message dropped")
+	if buf.String() != "Message received: hi\n[WARN] Message dropped\n" {
+		t.Errorf("Prefix should only pass matching messages: '%s'", buf.String())
+	}
+}
+
+type blockingTracer struct {
+	release chan struct{}
+	buf     bytes.Buffer
+}
+
+func (b *blockingTracer) Trace(a ...interface{}) {
+	<-b.release
+	New(&b.buf).Trace(a...)
+}
+
+func TestAsync(t *testing.T) {
+	bt := &blockingTracer{release: make(chan struct{})}
+	tracer := Async(bt, 1)
+	// 書き出し先がブロックしていても呼び出し側は待たされない
+	for i := 0; i < 10; i++ {
+		tracer.Trace("message")
+	}
+	if tracer.Dropped() == 0 {
+		t.Error("Async should drop messages when the buffer is full")
+	}
+	close(bt.release)
+	tracer.Close()
+	if bt.buf.Len() == 0 {
+		t.Error("Async should flush buffered messages on Close")
+	}
+	tracer.Trace("after close")
+}
+
+func TestAsyncLevel(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := Async(MinLevel(New(&buf), Warn), 8)
+	tracer.Trace(Info, "info")
+	tracer.Trace(Warn, "Send buffer full")
+	tracer.Close()
+	if buf.String() != "[WARN] Send buffer full\n" {
+		t.Errorf("Async should keep the level for the next tracer: '%s'", buf.String())
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// Result はドメインを調べた結果です
+type Result struct {
+	Domain    string
+	Available bool
+	// Method は結果を決めた方法 ("rdap", "whois") です
+	Method string
+	// Registrar は登録されている場合のレジストラーの名前です。分からない場合は空です
+	Registrar string
+}
+
+// Checker はドメインが登録されているかを調べます。
+// RDAPとWHOISのどちらで調べても同じResultを返します。
+type Checker interface {
+	Check(ctx context.Context, domain string) (Result, error)
+}
+
+// errUnsupported はCheckerがそのドメインを調べられないことを表し、次のCheckerに任せます
+var errUnsupported = errors.New("available: このドメインには対応していません")
+
+// fallbackChecker は前のCheckerから順に試し、エラーになったら次のCheckerで調べます
+type fallbackChecker []Checker
+
+func (c fallbackChecker) Check(ctx context.Context, domain string) (Result, error) {
+	err := errUnsupported
+	for _, checker := range c {
+		var r Result
+		if r, err = checker.Check(ctx, domain); err == nil {
+			return r, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return Result{Domain: domain}, err
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsResolver はdnsCheckerが使うDNSの問い合わせです。テストでは差し替えます
+type dnsResolver interface {
+	LookupNS(ctx context.Context, name string) ([]*net.NS, error)
+	// LookupSOA はnameをゾーンの頂点とするSOAレコードがあればtrueを返します
+	LookupSOA(ctx context.Context, name string) (bool, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// dnsChecker はDNSでドメインが使われているかを調べるCheckerです。
+// NSレコードかSOAレコードがあるドメインは登録されていると判断します。
+// 見つからない場合でも登録だけされていることがあるので、判断せずにerrUnsupportedを返し、
+// RDAPやWHOISに任せます。
+// hostsがtrueの場合はA/AAAAレコードも見ますが、存在しない名前にもアドレスを返すリゾルバーでは
+// すべてのドメインが使われていると判断してしまうので、既定では見ません。
+type dnsChecker struct {
+	resolver dnsResolver
+	timeout  time.Duration
+	hosts    bool
+}
+
+// newDNSChecker はaddressのDNSサーバーを使うdnsCheckerを作ります。addressが空の場合はシステムの設定を使います
+func newDNSChecker(address string, timeout time.Duration, hosts bool) *dnsChecker {
+	r := &netResolver{Resolver: &net.Resolver{}, servers: systemNameservers("/etc/resolv.conf")}
+	if address != "" {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, "53")
+		}
+		r.PreferGo = true
+		r.Dial = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		}
+		r.servers = []string{address}
+	}
+	return &dnsChecker{resolver: r, timeout: timeout, hosts: hosts}
+}
+
+func (c *dnsChecker) Check(ctx context.Context, domain string) (Result, error) {
+	result := Result{Domain: domain, Method: "dns"}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	name := strings.TrimSuffix(domain, ".") + "."
+	ns, err := c.resolver.LookupNS(ctx, name)
+	if len(ns) > 0 {
+		return result, nil
+	}
+	if err != nil && !isNotFound(err) {
+		return result, err
+	}
+	soa, err := c.resolver.LookupSOA(ctx, name)
+	if soa {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	if c.hosts {
+		addrs, err := c.resolver.LookupHost(ctx, name)
+		if len(addrs) > 0 {
+			return result, nil
+		}
+		if err != nil && !isNotFound(err) {
+			return result, err
+		}
+	}
+	return result, errUnsupported
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// netResolver は標準ライブラリのResolverに、SOAレコードの問い合わせを足したものです。
+// 標準ライブラリではSOAを引けないので、serversの最初のサーバーにUDPで直接問い合わせます
+type netResolver struct {
+	*net.Resolver
+	servers []string
+}
+
+func (r *netResolver) LookupSOA(ctx context.Context, name string) (bool, error) {
+	if len(r.servers) == 0 {
+		// 問い合わせ先が分からない場合はSOAは見ずに、NSだけで判断します
+		return false, nil
+	}
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return false, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+	query, err := soaQuery(id, name)
+	if err != nil {
+		return false, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", r.servers[0])
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return false, err
+	}
+	buf := make([]byte, 1232)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return false, err
+	}
+	return hasSOA(buf[:n], id, name)
+}
+
+func soaQuery(id uint16, name string) ([]byte, error) {
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: n, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// hasSOA は応答の回答にnameのSOAレコードがあるかを返します。NXDOMAINの場合はfalseです
+func hasSOA(msg []byte, id uint16, name string) (bool, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil {
+		return false, err
+	}
+	if h.ID != id {
+		return false, fmt.Errorf("dns: %s: 問い合わせと応答のIDが一致しません", name)
+	}
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return false, nil
+	default:
+		return false, fmt.Errorf("dns: %s: %v", name, h.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return false, err
+	}
+	for {
+		ah, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if ah.Type == dnsmessage.TypeSOA && strings.EqualFold(ah.Name.String(), name) {
+			return true, nil
+		}
+		if err := p.SkipAnswer(); err != nil {
+			return false, err
+		}
+	}
+}
+
+// systemNameservers はresolv.confに書かれたDNSサーバーを返します。読めない場合は空です
+func systemNameservers(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var servers []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	return servers
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// stubResolver はns、soa、hostsにある名前だけを返すdnsResolverです
+type stubResolver struct {
+	ns    map[string]bool
+	soa   map[string]bool
+	hosts map[string]bool
+	err   error
+}
+
+func (r *stubResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.ns[name] {
+		return []*net.NS{{Host: "ns1.example.net."}}, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *stubResolver) LookupSOA(ctx context.Context, name string) (bool, error) {
+	return r.soa[name], nil
+}
+
+func (r *stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if r.hosts[host] {
+		return []string{"192.0.2.1"}, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestDNSChecker(t *testing.T) {
+	resolver := &stubResolver{
+		ns:    map[string]bool{"taken.com.": true},
+		soa:   map[string]bool{"apex.com.": true},
+		hosts: map[string]bool{"parked.com.": true},
+	}
+	c := &dnsChecker{resolver: resolver, timeout: time.Second}
+	ctx := context.Background()
+	for _, domain := range []string{"taken.com", "apex.com"} {
+		if r, err := c.Check(ctx, domain); err != nil || r.Available || r.Method != "dns" {
+			t.Errorf("Check(%s) = %+v, %v", domain, r, err)
+		}
+	}
+	for _, domain := range []string{"free.com", "parked.com"} {
+		if _, err := c.Check(ctx, domain); !errors.Is(err, errUnsupported) {
+			t.Errorf("DNS should not decide %s from NS/SOA alone, got %v", domain, err)
+		}
+	}
+	c.hosts = true
+	if r, err := c.Check(ctx, "parked.com"); err != nil || r.Available {
+		t.Errorf("addresses should count when hosts is enabled, got %+v, %v", r, err)
+	}
+	resolver.err = errors.New("timeout")
+	if _, err := c.Check(ctx, "taken.com"); err == nil || errors.Is(err, errUnsupported) {
+		t.Errorf("resolver errors should be returned, got %v", err)
+	}
+}
+
+func TestDNSPrecheck(t *testing.T) {
+	dns := &dnsChecker{resolver: &stubResolver{ns: map[string]bool{"taken.com.": true}}, timeout: time.Second}
+	whois := &fixedChecker{result: Result{Available: true, Method: "whois"}}
+	c := fallbackChecker{dns, whois}
+	ctx := context.Background()
+	if r, _ := c.Check(ctx, "taken.com"); r.Method != "dns" || r.Available || whois.calls != 0 {
+		t.Errorf("domains found in DNS should be decided without WHOIS, got %+v", r)
+	}
+	if r, _ := c.Check(ctx, "free.com"); r.Method != "whois" || !r.Available || whois.calls != 1 {
+		t.Errorf("domains missing from DNS should be checked with WHOIS, got %+v", r)
+	}
+}
+
+// serveSOA はnameにだけSOAレコードを返し、他の名前にはNXDOMAINを返すDNSサーバーを起動します
+func serveSOA(t *testing.T, name string) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("cannot listen on UDP:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: h.ID, Response: true, RCode: dnsmessage.RCodeNameError},
+				Questions: []dnsmessage.Question{q},
+			}
+			if q.Name.String() == name {
+				resp.Header.RCode = dnsmessage.RCodeSuccess
+				resp.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET},
+					Body: &dnsmessage.SOAResource{
+						NS:   dnsmessage.MustNewName("ns1.example.net."),
+						MBox: dnsmessage.MustNewName("hostmaster.example.net."),
+					},
+				}}
+			}
+			b, _ := resp.Pack()
+			conn.WriteTo(b, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestLookupSOA(t *testing.T) {
+	r := &netResolver{Resolver: &net.Resolver{}, servers: []string{serveSOA(t, "taken.com.")}}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if soa, err := r.LookupSOA(ctx, "taken.com."); err != nil || !soa {
+		t.Errorf("LookupSOA(taken.com.) = %v, %v", soa, err)
+	}
+	if soa, err := r.LookupSOA(ctx, "free.com."); err != nil || soa {
+		t.Errorf("NXDOMAIN should mean no SOA, got %v, %v", soa, err)
+	}
+	if soa, err := (&netResolver{}).LookupSOA(ctx, "taken.com."); err != nil || soa {
+		t.Errorf("without servers SOA should be skipped, got %v, %v", soa, err)
+	}
+}
+
+func TestSystemNameservers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	os.WriteFile(path, []byte("# comment\nnameserver 192.0.2.53\nsearch example.com\nnameserver 2001:db8::53\n"), 0644)
+	got := systemNameservers(path)
+	if want := []string{"192.0.2.53:53", "[2001:db8::53]:53"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("systemNameservers = %v, want %v", got, want)
+	}
+}
@@ -1,3 +1,9 @@
 module github.com/taitai9847/goblueprints/ch4/available
 
 go 1.17
+
+require github.com/taitai9847/goblueprints/ch4/pipeline v0.0.0
+
+require golang.org/x/net v0.7.0
+
+replace github.com/taitai9847/goblueprints/ch4/pipeline => ../pipeline
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// hostLimiter はホストごとに、問い合わせの間隔がinterval以上空くように待たせます。
+// WHOISサーバーは短い間隔で問い合わせると応答を拒否することがあるためです。
+type hostLimiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	// next はホストごとの次に問い合わせてよい時刻
+	next map[string]time.Time
+	now  func() time.Time
+}
+
+func newHostLimiter(interval time.Duration) *hostLimiter {
+	return &hostLimiter{interval: interval, next: map[string]time.Time{}, now: time.Now}
+}
+
+// wait はhostに問い合わせてよい時刻まで待ちます。ctxが終わった場合はそのエラーを返します
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	if l == nil || l.interval <= 0 {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := l.now()
+	at := l.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[host] = at.Add(l.interval)
+	l.mu.Unlock()
+	d := at.Sub(now)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
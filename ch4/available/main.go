@@ -1,44 +1,120 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
+	"context"
+	"flag"
 	"log"
-	"net"
 	"os"
-	"strings"
+	"path/filepath"
 	"time"
+
+	"github.com/taitai9847/goblueprints/ch4/pipeline"
 )
 
 var marks = map[bool]string{true: "◯", false: "✖️"}
 
+var (
+	rdapBootstrapPath = flag.String("rdap-bootstrap", defaultBootstrapPath(), "RDAPのブートストラップのファイル (IANAのdns.jsonと同じ形式)。既定では実行ファイルと同じディレクトリのdns.jsonを探すので、go runでは見つからずWHOISだけを使います")
+	concurrency       = flag.Int("concurrency", 8, "同時に調べるドメインの数")
+	timeout           = flag.Duration("timeout", 10*time.Second, "1回の問い合わせの時間の上限")
+	whoisInterval     = flag.Duration("whois-interval", time.Second, "同じWHOISサーバーに問い合わせる間隔")
+	format            = flag.String("format", pipeline.FormatText, "出力の形式 (text, jsonl, csv)")
+	dnsPrecheck       = flag.Bool("dns", true, "WHOISやRDAPの前にDNSで調べ、使われているドメインはそこで判断します")
+	dnsHosts          = flag.Bool("dns-hosts", false, "DNSで調べるときにA/AAAAレコードも見ます。存在しないドメインにもアドレスを返すリゾルバーでは、すべてのドメインが使われていると判断されます")
+	resolver          = flag.String("resolver", "", "DNSの問い合わせに使うサーバー (例: 8.8.8.8:53)。空の場合はシステムの設定を使います")
+	ordered           = flag.Bool("ordered", true, "入力と同じ順番で出力します。falseの場合は調べ終わった順に出力します")
+)
+
 func main() {
-	s := bufio.NewScanner(os.Stdin)
-	for s.Scan() {
-		domain := s.Text()
-		fmt.Print(domain, " ")
-		exist, err := exists(domain)
+	flag.Parse()
+	os.Exit(run())
+}
+
+// run はドメインを調べて書き出し、終了コードを返します。
+// 1つのドメインを調べられなくても、他のドメインの結果は捨てずに最後まで続けます
+func run() int {
+	w, err := pipeline.NewWriter(os.Stdout, *format)
+	if err != nil {
+		log.Println(err)
+		return 2
+	}
+	w.Text = func(rec pipeline.Record) string {
+		return rec.Word + " " + marks[*rec.Available] + " " + rec.Method
+	}
+	checker := newChecker()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	domains := make(chan pipeline.Record)
+	var readErr error
+	go func() {
+		defer close(domains)
+		r := pipeline.NewReader(os.Stdin)
+		for r.Scan() {
+			select {
+			case domains <- r.Record():
+			case <-ctx.Done():
+				return
+			}
+		}
+		readErr = r.Err()
+	}()
+	report := &reporter{w: os.Stderr}
+	defer report.summary()
+	var writeErr error
+	checkAll(ctx, checker, domains, *concurrency, *ordered, func(rec pipeline.Record, result Result, err error) {
+		if writeErr != nil {
+			return
+		}
 		if err != nil {
-			log.Fatalln(err)
+			report.problem(result.Domain, err)
+			return
+		}
+		report.ok(result)
+		if rec.TLD == "" {
+			rec.TLD = tldOf(result.Domain)
 		}
-		fmt.Println(marks[!exist])
-		time.Sleep(1 * time.Second)
+		rec.Available = &result.Available
+		rec.Method = result.Method
+		rec.Registrar = result.Registrar
+		if writeErr = w.Write(rec); writeErr != nil {
+			// 出力先が閉じられた場合は、残りを調べても書き出せないので止めます
+			cancel()
+		}
+	})
+	if writeErr != nil {
+		log.Println(writeErr)
+		return 1
+	}
+	if readErr != nil {
+		log.Println(readErr)
+		return 1
 	}
+	return 0
 }
 
-func exists(domain string) (bool, error) {
-	const whoisServer string = "com.whois-servers.net"
-	conn, err := net.Dial("tcp", whoisServer+":43")
+// defaultBootstrapPath は実行ファイルと同じディレクトリのdns.jsonです
+func defaultBootstrapPath() string {
+	exe, err := os.Executable()
 	if err != nil {
-		return false, err
+		return "dns.json"
 	}
-	defer conn.Close()
-	conn.Write([]byte(domain + "\r\n"))
-	scanner := bufio.NewScanner(conn)
-	for scanner.Scan() {
-		if strings.Contains(strings.ToLower(scanner.Text()), "no match") {
-			return false, nil
-		}
+	return filepath.Join(filepath.Dir(exe), "dns.json")
+}
+
+// newChecker はDNS、RDAP、WHOISの順に、決められなかったときや失敗したときに次の方法で調べるCheckerを作ります
+func newChecker() Checker {
+	var checkers fallbackChecker
+	if *dnsPrecheck {
+		checkers = append(checkers, newDNSChecker(*resolver, *timeout, *dnsHosts))
+	}
+	if rdap, err := loadRDAPBootstrap(*rdapBootstrapPath); err == nil {
+		rdap.client.Timeout = *timeout
+		checkers = append(checkers, rdap)
+	} else {
+		log.Println("RDAPは使いません:", err)
 	}
-	return true, nil
+	whois := newWhoisRegistry()
+	whois.timeout = *timeout
+	whois.limiter = newHostLimiter(*whoisInterval)
+	return append(checkers, whois)
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/taitai9847/goblueprints/ch4/pipeline"
+)
+
+// checkAll はdomainsから読んだ候補のドメインをconcurrency個のワーカーで調べ、候補と結果をemitに渡します。
+// orderedの場合は入力の順番に、そうでなければ調べ終わった順に渡します。
+// emitは1つのgoroutineから呼ばれます。
+func checkAll(ctx context.Context, checker Checker, domains <-chan pipeline.Record, concurrency int, ordered bool, emit func(pipeline.Record, Result, error)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	type job struct {
+		index int
+		rec   pipeline.Record
+	}
+	type done struct {
+		job
+		result Result
+		err    error
+	}
+	jobs := make(chan job)
+	results := make(chan done)
+	go func() {
+		defer close(jobs)
+		i := 0
+		for rec := range domains {
+			select {
+			case jobs <- job{i, rec}:
+				i++
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				r, err := checker.Check(ctx, j.rec.Word)
+				r.Domain = j.rec.Word
+				results <- done{j, r, err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// 順番を守る場合は、先に終わった結果を前の結果が揃うまで取っておきます
+	pending := map[int]done{}
+	next := 0
+	for d := range results {
+		if !ordered {
+			emit(d.rec, d.result, d.err)
+			continue
+		}
+		pending[d.index] = d
+		for {
+			d, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			emit(d.rec, d.result, d.err)
+			next++
+		}
+	}
+}
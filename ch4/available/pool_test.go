@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/taitai9847/goblueprints/ch4/pipeline"
+)
+
+// gateChecker は調べ始めたドメインをstartedで知らせ、releaseのチャネルが閉じられるまで結果を返しません。
+// 調べ終わる順番をテストから決められるので、時間に頼らずに順番を確かめられます
+type gateChecker struct {
+	mu      sync.Mutex
+	running int
+	max     int
+	started chan string
+	release map[string]chan struct{}
+}
+
+func newGateChecker(domains []string) *gateChecker {
+	c := &gateChecker{started: make(chan string, len(domains)), release: map[string]chan struct{}{}}
+	for _, d := range domains {
+		c.release[d] = make(chan struct{})
+	}
+	return c
+}
+
+func (c *gateChecker) Check(ctx context.Context, domain string) (Result, error) {
+	c.mu.Lock()
+	c.running++
+	if c.running > c.max {
+		c.max = c.running
+	}
+	c.mu.Unlock()
+	c.started <- domain
+	<-c.release[domain]
+	c.mu.Lock()
+	c.running--
+	c.mu.Unlock()
+	return Result{Available: strings.HasPrefix(domain, "free"), Method: "gate"}, nil
+}
+
+// startCheckAll はcheckAllを別のgoroutineで動かし、渡された結果のドメインを順に返すチャネルを返します
+func startCheckAll(checker Checker, domains []string, concurrency int, ordered bool) <-chan string {
+	in := make(chan pipeline.Record)
+	go func() {
+		defer close(in)
+		for _, d := range domains {
+			in <- pipeline.Record{Word: d}
+		}
+	}()
+	out := make(chan string, len(domains))
+	go func() {
+		defer close(out)
+		checkAll(context.Background(), checker, in, concurrency, ordered, func(rec pipeline.Record, r Result, err error) {
+			if rec.Word != r.Domain {
+				panic("the record and the result should match")
+			}
+			out <- r.Domain
+		})
+	}()
+	return out
+}
+
+func TestCheckAll(t *testing.T) {
+	domains := []string{"a.io", "bb.io", "ccc.io", "dddd.io"}
+	reversed := []string{"dddd.io", "ccc.io", "bb.io", "a.io"}
+
+	// 後ろから終わらせても、orderedなら入力の順番で出力されます
+	c := newGateChecker(domains)
+	out := startCheckAll(c, domains, 4, true)
+	for range domains {
+		<-c.started
+	}
+	for _, d := range reversed {
+		close(c.release[d])
+	}
+	var got []string
+	for d := range out {
+		got = append(got, d)
+	}
+	if !reflect.DeepEqual(got, domains) {
+		t.Errorf("ordered output = %v, want %v", got, domains)
+	}
+	if c.max != 4 {
+		t.Errorf("domains should be checked concurrently up to the limit, max was %d", c.max)
+	}
+
+	// 調べ終わった順では、終わらせた順番のまま出力されます
+	c = newGateChecker(domains)
+	out = startCheckAll(c, domains, 4, false)
+	for range domains {
+		<-c.started
+	}
+	got = nil
+	for _, d := range reversed {
+		close(c.release[d])
+		got = append(got, <-out)
+	}
+	if !reflect.DeepEqual(got, reversed) {
+		t.Errorf("streamed output = %v, want %v", got, reversed)
+	}
+
+	c = newGateChecker(domains)
+	out = startCheckAll(c, domains, 1, true)
+	for range domains {
+		close(c.release[<-c.started])
+	}
+	for range out {
+	}
+	if c.max != 1 {
+		t.Errorf("concurrency 1 should check one domain at a time, max was %d", c.max)
+	}
+}
+
+func TestHostLimiter(t *testing.T) {
+	l := newHostLimiter(time.Hour)
+	now := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+	if err := l.wait(ctx, "whois.example"); err != nil {
+		t.Fatalf("the first query should not wait: %v", err)
+	}
+	if err := l.wait(ctx, "whois.other"); err != nil {
+		t.Fatalf("other hosts should not wait: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx, "whois.example"); err != context.DeadlineExceeded {
+		t.Errorf("the second query to the same host should wait, got %v", err)
+	}
+}
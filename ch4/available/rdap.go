@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// rdapClient はRDAPでドメインが登録されているかを調べるCheckerです。
+// TLDごとのRDAPサーバーは、IANAが公開しているブートストラップのファイル
+// (https://data.iana.org/rdap/dns.json) と同じ形式のファイルから読み込みます。
+type rdapClient struct {
+	// servers はTLDごとのRDAPサーバーのURL
+	servers map[string]string
+	client  *http.Client
+}
+
+// rdapBootstrap はRFC 9224のブートストラップのファイルです
+type rdapBootstrap struct {
+	Services [][][]string `json:"services"`
+}
+
+// loadRDAPBootstrap はpathのブートストラップのファイルを読み込んでrdapClientを作ります
+func loadRDAPBootstrap(path string) (*rdapClient, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var b rdapBootstrap
+	if err := json.NewDecoder(f).Decode(&b); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	c := &rdapClient{
+		servers: map[string]string{},
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, service := range b.Services {
+		if len(service) != 2 || len(service[1]) == 0 {
+			continue
+		}
+		// 複数のURLがある場合はhttpsのものを優先します
+		base := service[1][0]
+		for _, u := range service[1] {
+			if strings.HasPrefix(u, "https://") {
+				base = u
+				break
+			}
+		}
+		for _, tld := range service[0] {
+			c.servers[strings.ToLower(tld)] = base
+		}
+	}
+	return c, nil
+}
+
+func (c *rdapClient) Check(ctx context.Context, domain string) (Result, error) {
+	result := Result{Domain: domain, Method: "rdap"}
+	base, ok := c.servers[tldOf(domain)]
+	if !ok {
+		return result, errUnsupported
+	}
+	u := strings.TrimSuffix(base, "/") + "/domain/" + url.PathEscape(strings.TrimSuffix(domain, "."))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+	res, err := c.client.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		var body rdapDomain
+		if json.NewDecoder(io.LimitReader(res.Body, 1024*1024)).Decode(&body) == nil {
+			result.Registrar = body.registrar()
+		}
+		return result, nil
+	case http.StatusNotFound:
+		result.Available = true
+		return result, nil
+	}
+	return result, fmt.Errorf("rdap: %s: %s", domain, res.Status)
+}
+
+// rdapDomain はRDAPのドメインの応答のうち、使う部分です
+type rdapDomain struct {
+	Entities []struct {
+		Roles []string `json:"roles"`
+		// VCardArray は ["vcard", [["fn", {}, "text", "名前"], ...]] の形のjCard (RFC 7095) です
+		VCardArray []json.RawMessage `json:"vcardArray"`
+	} `json:"entities"`
+}
+
+// registrar はroleがregistrarのentityの名前を返します
+func (d rdapDomain) registrar() string {
+	for _, e := range d.Entities {
+		for _, role := range e.Roles {
+			if role != "registrar" || len(e.VCardArray) < 2 {
+				continue
+			}
+			var props [][]interface{}
+			if json.Unmarshal(e.VCardArray[1], &props) != nil {
+				continue
+			}
+			for _, p := range props {
+				if len(p) == 4 && p[0] == "fn" {
+					if name, ok := p[3].(string); ok {
+						return name
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
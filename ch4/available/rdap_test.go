@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newStubRDAP はRDAPサーバーをhttpsで起動し、そのサーバーを使うrdapClientを返します。
+// .comと.netは別のサーバーで、.comのサーバーにはtaken.comだけが、.netのサーバーにはtaken.netだけが登録されています
+func newStubRDAP(t *testing.T) *rdapClient {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/com/domain/taken.com", "/net/domain/taken.net":
+			w.Header().Set("Content-Type", "application/rdap+json")
+			fmt.Fprint(w, `{"objectClassName": "domain", "ldhName": "TAKEN.COM", "entities": [
+				{"roles": ["registrant"], "vcardArray": ["vcard", [["fn", {}, "text", "Someone"]]]},
+				{"roles": ["registrar"], "vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Example Registrar, Inc."]]]}
+			]}`)
+		case "/com/domain/broken.com":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	path := filepath.Join(t.TempDir(), "dns.json")
+	bootstrap := fmt.Sprintf(`{"services": [
+		[["com"], ["http://example.invalid/", "%s/com/"]],
+		[["NET"], ["%s/net/"]]
+	]}`, ts.URL, ts.URL)
+	if err := os.WriteFile(path, []byte(bootstrap), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadRDAPBootstrap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.client = ts.Client()
+	return c
+}
+
+func TestRDAPClient(t *testing.T) {
+	c := newStubRDAP(t)
+	ctx := context.Background()
+	if r, err := c.Check(ctx, "taken.com"); err != nil || r.Available || r.Method != "rdap" || r.Registrar != "Example Registrar, Inc." {
+		t.Errorf("Check(taken.com) = %+v, %v", r, err)
+	}
+	if r, err := c.Check(ctx, "free.com"); err != nil || !r.Available {
+		t.Errorf("Check(free.com) = %+v, %v", r, err)
+	}
+	if _, err := c.Check(ctx, "broken.com"); err == nil {
+		t.Error("server errors should be returned")
+	}
+	if _, err := c.Check(ctx, "example.jp"); !errors.Is(err, errUnsupported) {
+		t.Errorf("TLDs missing from the bootstrap should be unsupported, got %v", err)
+	}
+	if _, ok := c.servers["net"]; !ok {
+		t.Error("TLDs in the bootstrap should be case insensitive")
+	}
+	// .netを.comのサーバーに問い合わせると見つからず、空いていると判断されてしまいます
+	if r, err := c.Check(ctx, "taken.net"); err != nil || r.Available {
+		t.Errorf("Check(taken.net) should ask the .net server, got %+v, %v", r, err)
+	}
+}
+
+func TestRDAPBootstrapFile(t *testing.T) {
+	c, err := loadRDAPBootstrap("dns.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.servers["com"] == c.servers["net"] {
+		t.Errorf(".com and .net should use their own RDAP servers, both use %s", c.servers["com"])
+	}
+}
+
+// fixedChecker はいつも同じ結果を返すCheckerです
+type fixedChecker struct {
+	result Result
+	err    error
+	calls  int
+}
+
+func (c *fixedChecker) Check(ctx context.Context, domain string) (Result, error) {
+	c.calls++
+	c.result.Domain = domain
+	return c.result, c.err
+}
+
+func TestFallbackChecker(t *testing.T) {
+	ctx := context.Background()
+	whois := &fixedChecker{result: Result{Method: "whois"}}
+	c := fallbackChecker{newStubRDAP(t), whois}
+	if r, _ := c.Check(ctx, "free.com"); r.Method != "rdap" || whois.calls != 0 {
+		t.Errorf("RDAP should decide when it can, got %+v", r)
+	}
+	for _, domain := range []string{"broken.com", "example.jp"} {
+		if r, err := c.Check(ctx, domain); err != nil || r.Method != "whois" || r.Domain != domain {
+			t.Errorf("Check(%s) should fall back to WHOIS, got %+v, %v", domain, r, err)
+		}
+	}
+	failing := fallbackChecker{&fixedChecker{err: errors.New("rdap down")}, &fixedChecker{err: errors.New("whois down")}}
+	if _, err := failing.Check(ctx, "free.com"); err == nil || err.Error() != "whois down" {
+		t.Errorf("the last error should be returned, got %v", err)
+	}
+}
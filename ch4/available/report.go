@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// reporter は調べられなかったドメインを1行ずつ標準エラー出力に書き、最後に集計を書きます。
+// 各行は "available: level=error domain=\"chat.com\" error=\"...\"" のような key=value 形式です。
+type reporter struct {
+	w         io.Writer
+	domains   int
+	available int
+	taken     int
+	failed    int
+}
+
+func (r *reporter) ok(result Result) {
+	r.domains++
+	if result.Available {
+		r.available++
+	} else {
+		r.taken++
+	}
+}
+
+// problem はdomainを調べられなかったことを記録します。そのドメインは出力しません
+func (r *reporter) problem(domain string, err error) {
+	r.domains++
+	r.failed++
+	fmt.Fprintf(r.w, "available: level=error domain=%s action=skip error=%s\n", strconv.Quote(domain), strconv.Quote(err.Error()))
+}
+
+func (r *reporter) summary() {
+	fmt.Fprintf(r.w, "available: level=info domains=%d available=%d taken=%d failed=%d\n", r.domains, r.available, r.taken, r.failed)
+}
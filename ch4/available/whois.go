@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// whoisServer はTLDを管理するレジストリのWHOISサーバーです
+type whoisServer struct {
+	Host string
+	// Query はサーバーに送る問い合わせの書式で、%sがドメイン名になります。空の場合はドメイン名だけを送ります
+	Query string
+	// NotFound はドメインが登録されていないときの応答に含まれる文字列です (大文字小文字は区別しません)
+	NotFound []string
+}
+
+// defaultNotFound はNotFoundを指定していないサーバーに使う文字列です
+var defaultNotFound = []string{
+	"no match",
+	"not found",
+	"no data found",
+	"no entries found",
+	"no object found",
+	"status: free",
+	"status: available",
+}
+
+var (
+	verisign = &whoisServer{Host: "whois.verisign-grs.com", Query: "domain %s", NotFound: []string{"no match for"}}
+	google   = &whoisServer{Host: "whois.nic.google", NotFound: []string{"domain not found"}}
+	identity = &whoisServer{Host: "whois.identity.digital", NotFound: []string{"domain not found", "not found"}}
+)
+
+// builtinWhoisServers はよく使うTLDのWHOISサーバーです。
+// ここにないTLDはIANAのWHOISサーバーに問い合わせて調べます。
+var builtinWhoisServers = map[string]*whoisServer{
+	"com":  verisign,
+	"net":  verisign,
+	"org":  {Host: "whois.pir.org", NotFound: []string{"domain not found", "not found"}},
+	"info": identity,
+	"io":   identity,
+	"ai":   {Host: "whois.nic.ai", NotFound: []string{"not found"}},
+	"co":   {Host: "whois.registry.co", NotFound: []string{"no data found", "not found"}},
+	"me":   identity,
+	"dev":  google,
+	"app":  google,
+	"page": google,
+	"jp":   {Host: "whois.jprs.jp", Query: "%s/e", NotFound: []string{"no match!!"}},
+	"uk":   {Host: "whois.nic.uk", NotFound: []string{"no match for"}},
+	"de":   {Host: "whois.denic.de", Query: "-T dn,ace %s", NotFound: []string{"status: free"}},
+	"fr":   {Host: "whois.nic.fr", NotFound: []string{"%% not found", "no entries found"}},
+	"us":   {Host: "whois.nic.us", NotFound: []string{"no data found", "not found"}},
+}
+
+// ianaWhois はTLDのWHOISサーバーを教えてくれるIANAのWHOISサーバーです
+const ianaWhois = "whois.iana.org"
+
+// errNoWhoisServer はTLDにWHOISサーバーがないことを表します
+var errNoWhoisServer = errors.New("available: WHOISサーバーが見つかりません")
+
+// whoisRegistry はTLDごとのWHOISサーバーを管理し、ドメインが登録されているかを調べます
+type whoisRegistry struct {
+	mu      sync.Mutex
+	servers map[string]*whoisServer
+	// iana はTLDのWHOISサーバーを問い合わせるサーバーです
+	iana string
+	// dial はWHOISサーバーへの接続に使います。テストでは差し替えます
+	dial func(ctx context.Context, network, address string) (net.Conn, error)
+	// timeout は1回の問い合わせの時間の上限です
+	timeout time.Duration
+	// limiter はサーバーごとの問い合わせの間隔を空けます
+	limiter *hostLimiter
+}
+
+func newWhoisRegistry() *whoisRegistry {
+	r := &whoisRegistry{
+		servers: map[string]*whoisServer{},
+		iana:    ianaWhois,
+		timeout: 10 * time.Second,
+		limiter: newHostLimiter(time.Second),
+	}
+	var d net.Dialer
+	r.dial = d.DialContext
+	for tld, s := range builtinWhoisServers {
+		r.servers[tld] = s
+	}
+	return r
+}
+
+// tldOf はドメイン名の最後のラベルを返します。 "example.co.uk" の場合は "uk" です
+func tldOf(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	return domain[strings.LastIndex(domain, ".")+1:]
+}
+
+// server はtldのWHOISサーバーを返します。組み込みの表になければIANAに問い合わせ、結果を覚えておきます
+func (r *whoisRegistry) server(ctx context.Context, tld string) (*whoisServer, error) {
+	r.mu.Lock()
+	s, ok := r.servers[tld]
+	r.mu.Unlock()
+	if ok {
+		if s == nil {
+			return nil, errNoWhoisServer
+		}
+		return s, nil
+	}
+	lines, err := r.query(ctx, r.iana, tld)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		key, value, ok := cut(line, ":")
+		if ok && (key == "refer" || key == "whois") && value != "" {
+			s = &whoisServer{Host: value}
+			break
+		}
+	}
+	r.mu.Lock()
+	r.servers[tld] = s
+	r.mu.Unlock()
+	if s == nil {
+		return nil, errNoWhoisServer
+	}
+	return s, nil
+}
+
+// query はWHOISサーバーhostにqを送り、応答を行ごとに返します。
+// サーバーごとの間隔を空けるために待った後、timeoutで打ち切ります
+func (r *whoisRegistry) query(ctx context.Context, host, q string) ([]string, error) {
+	if err := r.limiter.wait(ctx, host); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	address := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		address = net.JoinHostPort(host, "43")
+	}
+	conn, err := r.dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	if _, err := conn.Write([]byte(q + "\r\n")); err != nil {
+		return nil, err
+	}
+	var lines []string
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// Check はdomainが登録されているかをTLDのWHOISサーバーに問い合わせて調べます。
+// 登録されている場合は応答の "Registrar:" の行からレジストラーの名前も取り出します
+func (r *whoisRegistry) Check(ctx context.Context, domain string) (Result, error) {
+	result := Result{Domain: domain, Method: "whois"}
+	tld := tldOf(domain)
+	s, err := r.server(ctx, tld)
+	if err != nil {
+		return result, fmt.Errorf("%s: %w", tld, err)
+	}
+	q := domain
+	if s.Query != "" {
+		q = fmt.Sprintf(s.Query, domain)
+	}
+	lines, err := r.query(ctx, s.Host, q)
+	if err != nil {
+		return result, err
+	}
+	patterns := s.NotFound
+	if len(patterns) == 0 {
+		patterns = defaultNotFound
+	}
+	for _, line := range lines {
+		if key, value, ok := cut(line, ":"); ok && strings.EqualFold(key, "registrar") && result.Registrar == "" {
+			result.Registrar = value
+		}
+		line = strings.ToLower(line)
+		for _, p := range patterns {
+			if strings.Contains(line, p) {
+				result.Available = true
+				result.Registrar = ""
+				return result, nil
+			}
+		}
+	}
+	return result, nil
+}
+
+// cut はsをsepの前後に分け、空白を除いて返します
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+len(sep):]), true
+	}
+	return s, "", false
+}
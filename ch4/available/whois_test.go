@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stubWhois は問い合わせと接続先を記録し、決めた応答を返すWHOISサーバーです
+type stubWhois struct {
+	mu      sync.Mutex
+	queries []string
+	respond func(address, query string) string
+}
+
+// registry はすべての接続をstubに向けるwhoisRegistryを返します
+func (s *stubWhois) registry(t *testing.T) *whoisRegistry {
+	r := newWhoisRegistry()
+	r.limiter = nil
+	r.dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			q, _ := bufio.NewReader(server).ReadString('\n')
+			q = strings.TrimSpace(q)
+			s.mu.Lock()
+			s.queries = append(s.queries, address+" "+q)
+			s.mu.Unlock()
+			server.Write([]byte(s.respond(address, q)))
+		}()
+		return client, nil
+	}
+	return r
+}
+
+func TestWhoisRegistry(t *testing.T) {
+	stub := &stubWhois{respond: func(address, q string) string {
+		switch {
+		case address == "whois.iana.org:43" && q == "xyz":
+			return "domain:       XYZ\nrefer:        whois.nic.xyz\n"
+		case address == "whois.iana.org:43":
+			return "% This query returned 0 objects.\n"
+		case strings.HasPrefix(q, "free"), strings.HasPrefix(q, "domain free"), strings.HasPrefix(q, "-T dn,ace free"):
+			return map[string]string{
+				"whois.verisign-grs.com:43": "No match for \"FREE.COM\".\n",
+				"whois.nic.google:43":       "Domain not found.\n",
+				"whois.denic.de:43":         "Domain: free.de\nStatus: free\n",
+				"whois.nic.xyz:43":          "DOMAIN NOT FOUND\n",
+			}[address]
+		}
+		return "Domain Name: TAKEN\n   Registrar: Example, Inc.\n"
+	}}
+	r := stub.registry(t)
+
+	tests := []struct {
+		domain string
+		exists bool
+		query  string
+	}{
+		{"free.com", false, "whois.verisign-grs.com:43 domain free.com"},
+		{"taken.net", true, "whois.verisign-grs.com:43 domain taken.net"},
+		{"free.dev", false, "whois.nic.google:43 free.dev"},
+		{"free.de", false, "whois.denic.de:43 -T dn,ace free.de"},
+		{"free.xyz", false, "whois.nic.xyz:43 free.xyz"},
+		{"taken.xyz", true, "whois.nic.xyz:43 taken.xyz"},
+	}
+	for _, test := range tests {
+		stub.queries = nil
+		result, err := r.Check(context.Background(), test.domain)
+		if err != nil {
+			t.Errorf("Check(%s): %s", test.domain, err)
+			continue
+		}
+		if result.Available == test.exists || result.Method != "whois" || test.exists && result.Registrar != "Example, Inc." {
+			t.Errorf("Check(%s) = %+v, want exists=%v", test.domain, result, test.exists)
+		}
+		if last := stub.queries[len(stub.queries)-1]; last != test.query {
+			t.Errorf("exists(%s) sent %q, want %q", test.domain, last, test.query)
+		}
+	}
+	// IANAへの問い合わせの結果は覚えておきます
+	stub.queries = nil
+	r.Check(context.Background(), "another.xyz")
+	if len(stub.queries) != 1 {
+		t.Errorf("the referral should be cached, got queries %v", stub.queries)
+	}
+	if _, err := r.Check(context.Background(), "example.unknown"); err == nil {
+		t.Error("TLDs without a WHOIS server should be an error")
+	}
+}
+
+func TestTLDOf(t *testing.T) {
+	for domain, want := range map[string]string{
+		"example.com":    "com",
+		"example.co.uk.": "uk",
+		"EXAMPLE.IO":     "io",
+	} {
+		if got := tldOf(domain); got != want {
+			t.Errorf("tldOf(%s) = %s, want %s", domain, got, want)
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
+	"flag"
+	"log"
 	"math/rand"
 	"os"
 	"time"
+
+	"github.com/taitai9847/goblueprints/ch4/pipeline"
 )
 
 // const (
@@ -25,11 +27,21 @@ func removeVowel(word []byte, i int) []byte {
 	return append(word[:i], word[i+1:]...)
 }
 
+var format = flag.String("format", pipeline.FormatText, "出力の形式 (text, jsonl, csv)")
+
 func main() {
+	flag.Parse()
 	rand.Seed(time.Now().UTC().UnixNano())
-	s := bufio.NewScanner(os.Stdin)
-	for s.Scan() {
-		word := []byte(s.Text())
+	w, err := pipeline.NewWriter(os.Stdout, *format)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	r := pipeline.NewReader(os.Stdin)
+	for r.Scan() {
+		rec := r.Record()
+		word := []byte(rec.Word)
+		// 母音を変えなかった場合は変換を記録しません
+		transform := ""
 		if randBool() {
 			var vI = -1
 			for i, char := range word {
@@ -43,11 +55,21 @@ func main() {
 			if vI >= 0 {
 				if randBool() {
 					word = duplicateVowel(word, vI)
+					transform = "coolify:duplicate"
 				} else {
 					word = removeVowel(word, vI)
+					transform = "coolify:remove"
 				}
 			}
 		}
-		fmt.Println(string(word))
+		if transform != "" {
+			rec = rec.Derive(string(word), transform)
+		}
+		if err := w.Write(rec); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if err := r.Err(); err != nil {
+		log.Fatalln(err)
 	}
 }
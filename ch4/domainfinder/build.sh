@@ -7,8 +7,9 @@ go build -o ../domainfinder/lib/synonyms
 echo Building available...
 cd ../available
 go build -o ../domainfinder/lib/available
+cp dns.json ../domainfinder/lib/dns.json
 echo Building sprinkle...
-cd ../sprinkle
+cd ../spinkle
 go build -o ../domainfinder/lib/sprinkle
 echo Building coolify...
 cd ../coolify
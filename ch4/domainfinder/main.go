@@ -1,23 +1,33 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"os"
 	"os/exec"
 )
 
+var format = flag.String("format", "text", "出力の形式 (text, jsonl, csv)")
+
+// 途中のコマンドはjsonlで受け渡し、元の単語や変換などの情報を最後まで伝えます
 var cmdChain = []*exec.Cmd{
-	exec.Command("lib/synonyms"),
-	exec.Command("lib/sprinkle"),
-	exec.Command("lib/coolify"),
-	exec.Command("lib/domainify"),
+	exec.Command("lib/synonyms", "-format", "jsonl"),
+	exec.Command("lib/sprinkle", "-format", "jsonl"),
+	exec.Command("lib/coolify", "-format", "jsonl"),
+	exec.Command("lib/domainify", "-format", "jsonl"),
 	exec.Command("lib/available"),
 }
 
 func main() {
+	flag.Parse()
+	last := cmdChain[len(cmdChain)-1]
+	last.Args = append(last.Args, "-format", *format)
 
 	cmdChain[0].Stdin = os.Stdin
-	cmdChain[len(cmdChain)-1].Stdout = os.Stdout
+	last.Stdout = os.Stdout
+	for _, cmd := range cmdChain {
+		cmd.Stderr = os.Stderr
+	}
 
 	for i := 0; i < len(cmdChain)-1; i++ {
 		thisCmd := cmdChain[i]
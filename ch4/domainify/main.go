@@ -1,24 +1,34 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
+	"flag"
+	"log"
 	"math/rand"
 	"os"
 	"strings"
 	"time"
 	"unicode"
+
+	"github.com/taitai9847/goblueprints/ch4/pipeline"
 )
 
 var tlds = []string{"com", "net"}
 
 const allowedChars = "abcdefghijklmnopqrstuvwxyz0123456789_-"
 
+var format = flag.String("format", pipeline.FormatText, "出力の形式 (text, jsonl, csv)")
+
 func main() {
+	flag.Parse()
 	rand.Seed(time.Now().UTC().UnixNano())
-	s := bufio.NewScanner(os.Stdin)
-	for s.Scan() {
-		text := strings.ToLower(s.Text())
+	w, err := pipeline.NewWriter(os.Stdout, *format)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	r := pipeline.NewReader(os.Stdin)
+	for r.Scan() {
+		rec := r.Record()
+		text := strings.ToLower(rec.Word)
 		var newText []rune
 		for _, r := range text {
 			if unicode.IsSpace(r) {
@@ -29,7 +39,14 @@ func main() {
 			}
 			newText = append(newText, r)
 		}
-		fmt.Println(string(newText) + "." +
-			tlds[rand.Intn(len(tlds))])
+		tld := tlds[rand.Intn(len(tlds))]
+		rec = rec.Derive(string(newText)+"."+tld, "domainify")
+		rec.TLD = tld
+		if err := w.Write(rec); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if err := r.Err(); err != nil {
+		log.Fatalln(err)
 	}
 }
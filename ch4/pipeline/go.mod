@@ -0,0 +1,3 @@
+module github.com/taitai9847/goblueprints/ch4/pipeline
+
+go 1.17
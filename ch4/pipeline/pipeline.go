@@ -0,0 +1,207 @@
+// Package pipeline はdomainfinderのコマンドの間で受け渡す候補の形式を扱います。
+//
+// 各コマンドは1行1件の候補を読み書きします。形式は次の3つです。
+//
+//   - text: 単語だけ。元の書籍と同じ形式です
+//   - jsonl: 1行1件のJSONで、元の単語や適用した変換などの情報を持ちます
+//   - csv: jsonlと同じ情報を、ヘッダー行の付いたCSVで表します
+//
+// Readerは行ごとに形式を判別するので、前のコマンドの-formatに関係なく読み込めます。
+package pipeline
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 出力の形式です
+const (
+	FormatText  = "text"
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// Record はドメイン名の候補1件です
+type Record struct {
+	// Word は現在の単語で、domainifyの後はドメイン名です
+	Word string `json:"word"`
+	// Source はsynonymsに入力された元の単語です
+	Source string `json:"source,omitempty"`
+	// Transforms は適用した変換を順に並べたものです ("synonym:noun/syn", "sprinkle:*app" など)
+	Transforms []string `json:"transforms,omitempty"`
+	TLD        string   `json:"tld,omitempty"`
+	// Available はavailableが調べた結果で、調べていない場合はnilです
+	Available *bool `json:"available,omitempty"`
+	// Method はavailableが結果を決めた方法です
+	Method    string `json:"method,omitempty"`
+	Registrar string `json:"registrar,omitempty"`
+}
+
+// Derive はrecに変換transformを適用してwordになった新しいRecordを返します
+func (rec Record) Derive(word, transform string) Record {
+	next := rec
+	if next.Source == "" {
+		next.Source = rec.Word
+	}
+	next.Word = word
+	next.Transforms = append(append([]string(nil), rec.Transforms...), transform)
+	return next
+}
+
+var csvHeader = []string{"word", "source", "transforms", "tld", "available", "method", "registrar"}
+
+func (rec Record) csvRow() []string {
+	available := ""
+	if rec.Available != nil {
+		available = strconv.FormatBool(*rec.Available)
+	}
+	return []string{rec.Word, rec.Source, strings.Join(rec.Transforms, ";"), rec.TLD, available, rec.Method, rec.Registrar}
+}
+
+func parseCSVRow(header, row []string) (Record, error) {
+	var rec Record
+	for i, name := range header {
+		if i >= len(row) {
+			break
+		}
+		v := row[i]
+		switch name {
+		case "word":
+			rec.Word = v
+		case "source":
+			rec.Source = v
+		case "transforms":
+			if v != "" {
+				rec.Transforms = strings.Split(v, ";")
+			}
+		case "tld":
+			rec.TLD = v
+		case "available":
+			if v != "" {
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					return rec, err
+				}
+				rec.Available = &b
+			}
+		case "method":
+			rec.Method = v
+		case "registrar":
+			rec.Registrar = v
+		}
+	}
+	return rec, nil
+}
+
+// ValidFormat はformatが対応している形式かを調べます
+func ValidFormat(format string) error {
+	switch format {
+	case FormatText, FormatJSONL, FormatCSV:
+		return nil
+	}
+	return fmt.Errorf("pipeline: 対応していない形式です: %q (text, jsonl, csv)", format)
+}
+
+// Reader は候補を1件ずつ読み込みます
+type Reader struct {
+	s      *bufio.Scanner
+	header []string
+	rec    Record
+	err    error
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{s: bufio.NewScanner(r)}
+}
+
+// Scan は次の候補を読み込みます。終わりに達したかエラーになった場合はfalseを返します
+func (r *Reader) Scan() bool {
+	for r.err == nil && r.s.Scan() {
+		line := r.s.Text()
+		switch {
+		case strings.TrimSpace(line) == "":
+			continue
+		case strings.HasPrefix(line, "{"):
+			r.rec = Record{}
+			if err := json.Unmarshal([]byte(line), &r.rec); err != nil {
+				r.err = fmt.Errorf("pipeline: JSONを読み込めません: %w", err)
+				return false
+			}
+			return true
+		case line == strings.Join(csvHeader, ","):
+			r.header = csvHeader
+			continue
+		case r.header != nil:
+			row, err := csv.NewReader(strings.NewReader(line)).Read()
+			if err == nil {
+				r.rec, err = parseCSVRow(r.header, row)
+			}
+			if err != nil {
+				r.err = fmt.Errorf("pipeline: CSVを読み込めません: %w", err)
+				return false
+			}
+			return true
+		default:
+			r.rec = Record{Word: line}
+			return true
+		}
+	}
+	if r.err == nil {
+		r.err = r.s.Err()
+	}
+	return false
+}
+
+// Record はScanで読み込んだ候補を返します
+func (r *Reader) Record() Record { return r.rec }
+
+func (r *Reader) Err() error { return r.err }
+
+// Writer は候補を指定した形式で1件ずつ書き出します。
+// 次のコマンドがすぐに読めるよう、Writeのたびに書き出します。
+type Writer struct {
+	// Text はtext形式で書き出す行です。nilの場合はWordを書きます
+	Text        func(Record) string
+	format      string
+	w           io.Writer
+	csv         *csv.Writer
+	wroteHeader bool
+}
+
+func NewWriter(w io.Writer, format string) (*Writer, error) {
+	if err := ValidFormat(format); err != nil {
+		return nil, err
+	}
+	return &Writer{format: format, w: w, csv: csv.NewWriter(w)}, nil
+}
+
+func (w *Writer) Write(rec Record) error {
+	switch w.format {
+	case FormatJSONL:
+		b, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w.w, "%s\n", b)
+		return err
+	case FormatCSV:
+		if !w.wroteHeader {
+			w.wroteHeader = true
+			w.csv.Write(csvHeader)
+		}
+		w.csv.Write(rec.csvRow())
+		w.csv.Flush()
+		return w.csv.Error()
+	}
+	line := rec.Word
+	if w.Text != nil {
+		line = w.Text(rec)
+	}
+	_, err := fmt.Fprintln(w.w, line)
+	return err
+}
@@ -0,0 +1,73 @@
+package pipeline
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	yes := true
+	records := []Record{
+		{Word: "chat"},
+		Record{Word: "chat"}.Derive("talk", "synonym:noun/syn").Derive("talkapp", "sprinkle:*app"),
+		{Word: "talk.com", Source: "chat", Transforms: []string{"domainify"}, TLD: "com", Available: &yes, Method: "rdap", Registrar: "Example, Inc."},
+	}
+	for _, format := range []string{FormatJSONL, FormatCSV} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range records {
+			if err := w.Write(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r := NewReader(&buf)
+		var got []Record
+		for r.Scan() {
+			got = append(got, r.Record())
+		}
+		if err := r.Err(); err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if !reflect.DeepEqual(got, records) {
+			t.Errorf("%s: got %+v, want %+v", format, got, records)
+		}
+	}
+}
+
+func TestDerive(t *testing.T) {
+	rec := Record{Word: "chat"}.Derive("talk", "synonym")
+	a := rec.Derive("talkapp", "sprinkle:*app")
+	b := rec.Derive("gotalk", "sprinkle:go*")
+	if a.Source != "chat" || !reflect.DeepEqual(a.Transforms, []string{"synonym", "sprinkle:*app"}) {
+		t.Errorf("unexpected record: %+v", a)
+	}
+	if !reflect.DeepEqual(b.Transforms, []string{"synonym", "sprinkle:go*"}) {
+		t.Errorf("derived records should not share transforms: %+v", b)
+	}
+}
+
+func TestTextFormat(t *testing.T) {
+	r := NewReader(strings.NewReader("chat\n\nhello world\n"))
+	var words []string
+	for r.Scan() {
+		words = append(words, r.Record().Word)
+	}
+	if !reflect.DeepEqual(words, []string{"chat", "hello world"}) {
+		t.Errorf("unexpected words: %v", words)
+	}
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, FormatText)
+	w.Text = func(rec Record) string { return rec.Word + " !" }
+	w.Write(Record{Word: "chat"})
+	if buf.String() != "chat !\n" {
+		t.Errorf("unexpected text output: %q", buf.String())
+	}
+	if _, err := NewWriter(&buf, "xml"); err == nil {
+		t.Error("unknown formats should be an error")
+	}
+}
@@ -1,3 +1,7 @@
 module github.com/taitai9847/goblueprints/ch4/spinkle
 
 go 1.17
+
+require github.com/taitai9847/goblueprints/ch4/pipeline v0.0.0
+
+replace github.com/taitai9847/goblueprints/ch4/pipeline => ../pipeline
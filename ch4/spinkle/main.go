@@ -1,12 +1,14 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
+	"flag"
+	"log"
 	"math/rand"
 	"os"
 	"strings"
 	"time"
+
+	"github.com/taitai9847/goblueprints/ch4/pipeline"
 )
 
 const otherWord = "*"
@@ -24,11 +26,24 @@ var transform = []string{
 	"lets" + otherWord,
 }
 
+var format = flag.String("format", pipeline.FormatText, "出力の形式 (text, jsonl, csv)")
+
 func main() {
+	flag.Parse()
 	rand.Seed(time.Now().UTC().UnixNano())
-	s := bufio.NewScanner(os.Stdin)
-	for s.Scan() {
+	w, err := pipeline.NewWriter(os.Stdout, *format)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	r := pipeline.NewReader(os.Stdin)
+	for r.Scan() {
+		rec := r.Record()
 		t := transform[rand.Intn(len(transform))]
-		fmt.Println(strings.Replace(t, otherWord, s.Text(), -1))
+		if err := w.Write(rec.Derive(strings.Replace(t, otherWord, rec.Word, -1), "sprinkle:"+t)); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if err := r.Err(); err != nil {
+		log.Fatalln(err)
 	}
 }
@@ -4,5 +4,10 @@ go 1.17
 
 require (
 	github.com/joho/godotenv v1.4.0
+	github.com/taitai9847/goblueprints/ch4/pipeline v0.0.0
 	github.com/taitai9847/goblueprints/ch4/thesaurus v0.0.0-20210928032213-8749194f999d
 )
+
+replace github.com/taitai9847/goblueprints/ch4/thesaurus => ../thesaurus
+
+replace github.com/taitai9847/goblueprints/ch4/pipeline => ../pipeline
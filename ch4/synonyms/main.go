@@ -1,38 +1,175 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
+	"errors"
+	"flag"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 
+	"github.com/taitai9847/goblueprints/ch4/pipeline"
 	"github.com/taitai9847/goblueprints/ch4/thesaurus"
 )
 
+var (
+	file        = flag.String("file", "", "オフラインで使う類語辞書のファイル (Moby形式またはMyThes形式)")
+	cachePath   = flag.String("cache", defaultCachePath(), "APIへの問い合わせの結果を保存するファイル (空にするとディスクに保存しません)。辞書ファイルを使う場合は保存しません")
+	cacheTTL    = flag.Duration("cache-ttl", 30*24*time.Hour, "見つかった類語をキャッシュする期間")
+	negativeTTL = flag.Duration("negative-ttl", 24*time.Hour, "類語が見つからなかった単語をキャッシュする期間")
+	pos         = flag.String("pos", "", "出力する品詞をカンマ区切りで指定します (noun,verb,adjective,adverb)。空の場合はすべて")
+	rel         = flag.String("rel", "syn", "出力する関係をカンマ区切りで指定します (syn,ant,rel,sim,usr)。空の場合はすべて")
+	format      = flag.String("format", pipeline.FormatText, "出力の形式 (text, jsonl, csv)")
+	onError     = flag.String("on-error", onErrorPassthrough, "類語が見つからないときや問い合わせに失敗したときの動作 (passthrough, skip, fail)")
+)
+
+func defaultCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "goblueprints", "synonyms.jsonl")
+}
+
 func main() {
-	// apiKey := os.Getenv("BHT_APIKEY")
-	err := godotenv.Load("../../.env")
+	flag.Parse()
+	os.Exit(run())
+}
+
+func run() int {
+	switch *onError {
+	case onErrorPassthrough, onErrorSkip, onErrorFail:
+	default:
+		log.Println("-on-error は passthrough, skip, fail のいずれかです:", *onError)
+		return 2
+	}
+	backend, err := newThesaurus()
 	if err != nil {
-		fmt.Printf("読み込み出来ませんでした: %v", err)
+		log.Println(err)
+		return 1
 	}
-	apiKey := os.Getenv("API_KEY")
-	fmt.Println("apiKey: ", apiKey)
+	path := *cachePath
+	// キャッシュは単語だけで引くので、辞書ファイルの結果をAPIの結果と同じファイルに混ぜないようにします
+	if _, local := backend.(*thesaurus.File); local {
+		path = ""
+	}
+	cache, err := thesaurus.NewCache(backend, thesaurus.CacheOptions{
+		Path:        path,
+		TTL:         *cacheTTL,
+		NegativeTTL: *negativeTTL,
+	})
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	defer cache.Close()
+	w, err := pipeline.NewWriter(os.Stdout, *format)
+	if err != nil {
+		log.Println(err)
+		return 2
+	}
+	report := &reporter{w: os.Stderr}
+	defer report.summary()
+	return expand(pipeline.NewReader(os.Stdin), w, cache, splitFlag(*pos), splitFlag(*rel), *onError, report)
+}
 
-	thesaurus := &thesaurus.BigHugh{APIKey: apiKey}
-	s := bufio.NewScanner(os.Stdin)
-	for s.Scan() {
-		word := s.Text()
-		syns, err := thesaurus.Synonyms(word)
-		if err != nil {
-			log.Fatalln("Failed when looking for synonyms for \""+word+"\"", err)
+// expand はrから読んだ単語をtで調べ、類語をwに書き出します。
+// 類語がない単語はonErrorに従って扱い、reportに記録します。戻り値は終了コードです
+func expand(r *pipeline.Reader, w *pipeline.Writer, t thesaurus.Lookuper, posFilter, relFilter map[string]bool, onError string, report *reporter) int {
+	for r.Scan() {
+		rec := r.Record()
+		word := rec.Word
+		found, err := t.Lookup(word)
+		syns := filter(found, posFilter, relFilter)
+		if err == nil && len(syns) == 0 {
+			err = thesaurus.ErrNotFound
 		}
-		if len(syns) == 0 {
-			log.Fatalln("Couldn't find any synonyms for \"" + word + "\"")
+		if err != nil {
+			action := onError
+			// APIキーが正しくない場合は、この後の問い合わせもすべて失敗するので終了します
+			if errors.Is(err, thesaurus.ErrBadKey) {
+				action = onErrorFail
+			}
+			report.problem(word, err, action)
+			switch action {
+			case onErrorPassthrough:
+				if err := w.Write(rec); err != nil {
+					log.Println(err)
+					return 1
+				}
+			case onErrorFail:
+				return 1
+			}
+			continue
 		}
+		report.ok()
 		for _, syn := range syns {
-			fmt.Println(syn)
+			if err := w.Write(rec.Derive(syn.Word, transformName(syn))); err != nil {
+				log.Println(err)
+				return 1
+			}
+		}
+	}
+	if err := r.Err(); err != nil {
+		log.Println(err)
+		return 1
+	}
+	return 0
+}
+
+// filter は品詞と関係で結果を絞り込み、単語の重複を除いて返します。条件が空の場合は絞り込みません
+func filter(found []thesaurus.Synonym, pos, rel map[string]bool) []thesaurus.Synonym {
+	var words []thesaurus.Synonym
+	seen := map[string]bool{}
+	for _, s := range found {
+		if len(pos) > 0 && !pos[s.PartOfSpeech] || len(rel) > 0 && !rel[string(s.Relation)] {
+			continue
+		}
+		if !seen[s.Word] {
+			seen[s.Word] = true
+			words = append(words, s)
 		}
 	}
+	return words
+}
+
+// transformName は出力する候補に記録する変換の名前で、"synonym:noun/syn" のように品詞と関係を含みます
+func transformName(s thesaurus.Synonym) string {
+	if s.PartOfSpeech == "" {
+		return "synonym:" + string(s.Relation)
+	}
+	return "synonym:" + s.PartOfSpeech + "/" + string(s.Relation)
+}
+
+func splitFlag(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = true
+		}
+	}
+	return set
+}
+
+// newThesaurus は-fileかTHESAURUS_FILEで辞書が指定されていればそれを、
+// なければBig Huge ThesaurusのAPIを使います
+func newThesaurus() (thesaurus.Thesaurus, error) {
+	path := *file
+	if path == "" {
+		path = os.Getenv("THESAURUS_FILE")
+	}
+	if path != "" {
+		return thesaurus.LoadFile(path)
+	}
+	// apiKey := os.Getenv("BHT_APIKEY")
+	// 標準出力は次のコマンドに渡るので、メッセージは標準エラー出力に書きます
+	err := godotenv.Load("../../.env")
+	if err != nil {
+		log.Printf("読み込み出来ませんでした: %v", err)
+	}
+	apiKey := os.Getenv("API_KEY")
+	return &thesaurus.BigHugh{APIKey: apiKey, MaxRetries: 3}, nil
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/taitai9847/goblueprints/ch4/thesaurus"
+)
+
+// 類語が見つからないときや問い合わせに失敗したときの動作です
+const (
+	// onErrorPassthrough は元の単語をそのまま出力します
+	onErrorPassthrough = "passthrough"
+	// onErrorSkip は何も出力せずに次の単語に進みます
+	onErrorSkip = "skip"
+	// onErrorFail はそこで終了します
+	onErrorFail = "fail"
+)
+
+// reporter は単語ごとの問題を1行ずつ標準エラー出力に書き、最後に集計を書きます。
+// 各行は "synonyms: level=warn word=\"chat\" reason=not_found ..." のような key=value 形式です。
+type reporter struct {
+	w        io.Writer
+	words    int
+	found    int
+	notFound int
+	failed   int
+}
+
+// reason はエラーの種類を表す短い名前を返します
+func reason(err error) string {
+	switch {
+	case errors.Is(err, thesaurus.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, thesaurus.ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, thesaurus.ErrBadKey):
+		return "bad_key"
+	}
+	return "error"
+}
+
+func (r *reporter) ok() {
+	r.words++
+	r.found++
+}
+
+// problem はwordの問題を記録します。action はその後の動作です
+func (r *reporter) problem(word string, err error, action string) {
+	r.words++
+	level := "error"
+	if errors.Is(err, thesaurus.ErrNotFound) {
+		r.notFound++
+		level = "warn"
+	} else {
+		r.failed++
+	}
+	fmt.Fprintf(r.w, "synonyms: level=%s word=%s reason=%s action=%s", level, strconv.Quote(word), reason(err), action)
+	if !errors.Is(err, thesaurus.ErrNotFound) {
+		fmt.Fprintf(r.w, " error=%s", strconv.Quote(err.Error()))
+	}
+	fmt.Fprintln(r.w)
+}
+
+func (r *reporter) summary() {
+	fmt.Fprintf(r.w, "synonyms: level=info words=%d found=%d not_found=%d failed=%d\n", r.words, r.found, r.notFound, r.failed)
+}
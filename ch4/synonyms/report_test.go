@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/taitai9847/goblueprints/ch4/pipeline"
+	"github.com/taitai9847/goblueprints/ch4/thesaurus"
+)
+
+// stubThesaurus はAPIに接続せずに、単語ごとに決めた結果かエラーを返します
+type stubThesaurus struct {
+	syns map[string][]thesaurus.Synonym
+	errs map[string]error
+}
+
+func (t *stubThesaurus) Lookup(term string) ([]thesaurus.Synonym, error) {
+	if err := t.errs[term]; err != nil {
+		return nil, err
+	}
+	if syns, ok := t.syns[term]; ok {
+		return syns, nil
+	}
+	return nil, thesaurus.ErrNotFound
+}
+
+func (t *stubThesaurus) Synonyms(term string) ([]string, error) {
+	syns, err := t.Lookup(term)
+	if err != nil {
+		return nil, err
+	}
+	return thesaurus.Words(syns), nil
+}
+
+func TestFilter(t *testing.T) {
+	found := []thesaurus.Synonym{
+		{Word: "talk", PartOfSpeech: "noun", Relation: thesaurus.Syn},
+		{Word: "talk", PartOfSpeech: "verb", Relation: thesaurus.Syn},
+		{Word: "silence", PartOfSpeech: "noun", Relation: thesaurus.Ant},
+		{Word: "gossip", PartOfSpeech: "verb", Relation: thesaurus.Rel},
+	}
+	tests := []struct {
+		name string
+		pos  string
+		rel  string
+		want []string
+	}{
+		{"no filters", "", "", []string{"talk", "silence", "gossip"}},
+		{"synonyms only", "", "syn", []string{"talk"}},
+		{"verbs only", "verb", "", []string{"talk", "gossip"}},
+		{"both", "noun", "syn,ant", []string{"talk", "silence"}},
+		{"nothing matches", "adverb", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, s := range filter(found, splitFlag(tt.pos), splitFlag(tt.rel)) {
+				got = append(got, s.Word)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReporter(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		action string
+		want   string
+	}{
+		{"not found", thesaurus.ErrNotFound, onErrorSkip,
+			`synonyms: level=warn word="chat" reason=not_found action=skip` + "\n"},
+		{"rate limited", fmt.Errorf("request failed: %w", thesaurus.ErrRateLimited), onErrorPassthrough,
+			`synonyms: level=error word="chat" reason=rate_limited action=passthrough error="request failed: thesaurus: APIの利用回数の上限に達しました"` + "\n"},
+		{"bad key", thesaurus.ErrBadKey, onErrorFail,
+			`synonyms: level=error word="chat" reason=bad_key action=fail error="thesaurus: APIキーが正しくありません"` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			r := &reporter{w: &buf}
+			r.problem("chat", tt.err, tt.action)
+			if buf.String() != tt.want {
+				t.Errorf("got %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	r := &reporter{w: &buf}
+	r.ok()
+	r.problem("a", thesaurus.ErrNotFound, onErrorSkip)
+	r.problem("b", thesaurus.ErrRateLimited, onErrorSkip)
+	buf.Reset()
+	r.summary()
+	if want := "synonyms: level=info words=3 found=1 not_found=1 failed=1\n"; buf.String() != want {
+		t.Errorf("summary: got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	stub := &stubThesaurus{
+		syns: map[string][]thesaurus.Synonym{
+			"chat":  {{Word: "talk", PartOfSpeech: "verb", Relation: thesaurus.Syn}},
+			"quiet": {{Word: "loud", PartOfSpeech: "adjective", Relation: thesaurus.Ant}},
+		},
+		errs: map[string]error{
+			"busy":   thesaurus.ErrRateLimited,
+			"locked": thesaurus.ErrBadKey,
+		},
+	}
+	tests := []struct {
+		name    string
+		input   string
+		onError string
+		want    []string
+		code    int
+		report  []string
+	}{
+		{"found", "chat\n", onErrorPassthrough, []string{"talk"}, 0, nil},
+		{"passthrough", "chat\nunknown\nbusy\n", onErrorPassthrough, []string{"talk", "unknown", "busy"}, 0,
+			[]string{"reason=not_found action=passthrough", "reason=rate_limited action=passthrough"}},
+		// 絞り込みで何も残らない単語も見つからなかったものとして扱います
+		{"skip", "quiet\nchat\n", onErrorSkip, []string{"talk"}, 0,
+			[]string{`word="quiet" reason=not_found action=skip`}},
+		{"fail", "unknown\nchat\n", onErrorFail, nil, 1,
+			[]string{"reason=not_found action=fail"}},
+		{"bad key always fails", "chat\nlocked\nchat\n", onErrorSkip, []string{"talk"}, 1,
+			[]string{"reason=bad_key action=fail"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out, errs bytes.Buffer
+			w, err := pipeline.NewWriter(&out, pipeline.FormatText)
+			if err != nil {
+				t.Fatal(err)
+			}
+			report := &reporter{w: &errs}
+			code := expand(pipeline.NewReader(strings.NewReader(tt.input)), w, stub, nil, splitFlag("syn"), tt.onError, report)
+			if code != tt.code {
+				t.Errorf("exit code: got %d, want %d", code, tt.code)
+			}
+			if got, want := strings.Fields(out.String()), strings.Join(tt.want, " "); strings.Join(got, " ") != want {
+				t.Errorf("output: got %v, want %v", got, tt.want)
+			}
+			lines := strings.FieldsFunc(errs.String(), func(r rune) bool { return r == '\n' })
+			if len(lines) != len(tt.report) {
+				t.Fatalf("report: got %q, want %d lines", errs.String(), len(tt.report))
+			}
+			for i, want := range tt.report {
+				if !strings.Contains(lines[i], want) {
+					t.Errorf("report line %d: got %q, want it to contain %q", i, lines[i], want)
+				}
+			}
+		})
+	}
+}
@@ -2,40 +2,157 @@ package thesaurus
 
 import (
 	"encoding/json"
-	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// DefaultBaseURL はBig Huge ThesaurusのAPIのURLです
+const DefaultBaseURL = "https://words.bighugelabs.com/api/2/"
+
+// BigHugh はBig Huge ThesaurusのAPIを使うThesaurusです
 type BigHugh struct {
 	APIKey string
+	// Client はAPIの呼び出しに使うクライアントです。nilの場合はTimeoutが10秒のクライアントを使います
+	Client *http.Client
+	// BaseURL はAPIのURLです。空の場合はDefaultBaseURLを使います
+	BaseURL string
+	// MaxRetries は通信のエラーや一時的なエラーのときに再試行する回数です
+	MaxRetries int
+	// Backoff は最初の再試行までの待ち時間で、再試行のたびに倍になります。0の場合は500ミリ秒です
+	Backoff time.Duration
+	// MaxRetryAfter はRetry-Afterに従って待つ最長の時間です。
+	// これより長く待つよう指示された場合は再試行せずにErrRateLimitedを返します。0の場合は1分です
+	MaxRetryAfter time.Duration
 }
 
-type synonyms struct {
-	//jsonでのfieldとGoでのfieldの関連づけ
-	Noun *words `json:"noun"`
-	Verb *words `json:"verb"`
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// partsOfSpeech はAPIが返す品詞で、結果はこの順に並べます
+var partsOfSpeech = []string{"noun", "verb", "adjective", "adverb"}
+
+// words は品詞ごとの、関係 (syn, ant, rel, sim, usr) ごとの単語です
+type words map[Relation][]string
+
+// Error はAPIがエラーを返したことを表します。
+// errors.IsでErrNotFound, ErrRateLimited, ErrBadKeyと比較できます。
+type Error struct {
+	Term       string
+	StatusCode int
+	// Message はAPIが返したエラーの本文です
+	Message string
+	Err     error
 }
 
-type words struct {
-	Syn []string `json:"syn"`
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("bighugh: \"%s\" の類語を取得できませんでした: %d %s", e.Term, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
 }
 
+func (e *Error) Unwrap() error { return e.Err }
+
 func (b *BigHugh) Synonyms(term string) ([]string, error) {
-	var syns []string
-	response, err := http.Get("http://words.bighugelabs.com/api/2/" + b.APIKey + "/" + term + "/json")
+	return synonymsOf(b.Lookup(term))
+}
+
+// Lookup はtermのすべての品詞の、すべての関係の単語を品詞ごとにまとめて返します
+func (b *BigHugh) Lookup(term string) ([]Synonym, error) {
+	data, err := b.fetch(term)
 	if err != nil {
-		return syns, errors.New("bighugh: Failed when looking for synonyms for \"" + term + "\"" + err.Error())
-	}
-	var data synonyms
-	defer response.Body.Close()
-	if err := json.NewDecoder(response.Body).Decode(&data); err != nil {
-		return syns, err
+		return nil, err
 	}
-	if data.Noun != nil {
-		syns = append(syns, data.Noun.Syn...)
+	var syns []Synonym
+	for _, pos := range partsOfSpeech {
+		for _, rel := range relations {
+			for _, word := range data[pos][rel] {
+				syns = append(syns, Synonym{Word: word, PartOfSpeech: pos, Relation: rel})
+			}
+		}
 	}
-	if data.Verb != nil {
-		syns = append(syns, data.Verb.Syn...)
+	if len(syns) == 0 {
+		return nil, &Error{Term: term, StatusCode: http.StatusNotFound, Err: ErrNotFound}
 	}
 	return syns, nil
 }
+
+func (b *BigHugh) fetch(term string) (map[string]words, error) {
+	backoff := b.Backoff
+	if backoff <= 0 {
+		backoff = 500 * time.Millisecond
+	}
+	maxRetryAfter := b.MaxRetryAfter
+	if maxRetryAfter <= 0 {
+		maxRetryAfter = time.Minute
+	}
+	for attempt := 0; ; attempt++ {
+		data, retryAfter, err := b.get(term)
+		if err == nil || retryAfter < 0 || retryAfter > maxRetryAfter || attempt >= b.MaxRetries {
+			return data, err
+		}
+		wait := backoff << attempt
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		time.Sleep(wait)
+	}
+}
+
+// get はAPIを1回呼び出します。retryAfterが負の場合は再試行しても結果が変わらないエラーです
+func (b *BigHugh) get(term string) (data map[string]words, retryAfter time.Duration, err error) {
+	base := b.BaseURL
+	if base == "" {
+		base = DefaultBaseURL
+	}
+	client := b.Client
+	if client == nil {
+		client = defaultClient
+	}
+	u := strings.TrimSuffix(base, "/") + "/" + url.PathEscape(b.APIKey) + "/" + url.PathEscape(term) + "/json"
+	response, err := client.Get(u)
+	if err != nil {
+		// URLにAPIキーが含まれるので、エラーには含めません
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return nil, 0, fmt.Errorf("bighugh: \"%s\" の類語を取得できませんでした: %w", term, err)
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(response.Body).Decode(&data); err != nil {
+			return nil, -1, fmt.Errorf("bighugh: \"%s\" の結果を読み込めません: %w", term, err)
+		}
+		return data, 0, nil
+	case http.StatusNotFound:
+		return nil, -1, &Error{Term: term, StatusCode: response.StatusCode, Err: ErrNotFound}
+	}
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	apiErr := &Error{Term: term, StatusCode: response.StatusCode, Message: strings.TrimSpace(string(body))}
+	retryAfter = -1
+	message := strings.ToLower(apiErr.Message)
+	switch {
+	case response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden,
+		strings.Contains(message, "inactive key"):
+		// 本文に "key" を含むだけの別のエラーをキーの誤りと取り違えないよう、既知の文言だけを見ます
+		apiErr.Err = ErrBadKey
+	case response.StatusCode == http.StatusTooManyRequests:
+		apiErr.Err = ErrRateLimited
+		retryAfter = 0
+		if s, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(s) * time.Second
+		}
+	case strings.Contains(message, "usage exceeded") || strings.Contains(message, "limit"):
+		// 1日の利用回数の上限なので再試行しません
+		apiErr.Err = ErrRateLimited
+	case response.StatusCode >= 500:
+		retryAfter = 0
+	}
+	return nil, retryAfter, apiErr
+}
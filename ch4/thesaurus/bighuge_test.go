@@ -0,0 +1,147 @@
+package thesaurus
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStubServer はBig Huge ThesaurusのAPIの代わりに、pathごとに決めた応答を返すサーバーを起動します
+func newStubServer(t *testing.T, handler http.HandlerFunc) *BigHugh {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return &BigHugh{APIKey: "key", BaseURL: ts.URL, Client: ts.Client(), Backoff: time.Millisecond}
+}
+
+func TestBigHughLookup(t *testing.T) {
+	var path string
+	b := newStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.EscapedPath()
+		w.Write([]byte(`{
+			"noun": {"syn": ["talk", "confab"]},
+			"verb": {"syn": ["talk"], "ant": ["listen"]},
+			"adjective": {"syn": ["chatty"]}
+		}`))
+	})
+	syns, err := b.Lookup("small talk/chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/key/small%20talk%2Fchat/json" {
+		t.Errorf("the term should be escaped, got %s", path)
+	}
+	want := []Synonym{
+		{"talk", "noun", Syn},
+		{"confab", "noun", Syn},
+		{"talk", "verb", Syn},
+		{"listen", "verb", Ant},
+		{"chatty", "adjective", Syn},
+	}
+	if !reflect.DeepEqual(syns, want) {
+		t.Errorf("Lookup = %v, want %v", syns, want)
+	}
+	words, _ := b.Synonyms("chat")
+	if want := []string{"talk", "confab", "chatty"}; !reflect.DeepEqual(words, want) {
+		t.Errorf("Synonyms = %v, want %v", words, want)
+	}
+}
+
+func TestBigHughErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   error
+	}{
+		{http.StatusNotFound, "", ErrNotFound},
+		{http.StatusInternalServerError, "Usage Exceeded", ErrRateLimited},
+		{http.StatusInternalServerError, "Inactive key", ErrBadKey},
+		{http.StatusServiceUnavailable, "Key-value store unavailable", nil},
+		{http.StatusUnauthorized, "", ErrBadKey},
+		{http.StatusTooManyRequests, "", ErrRateLimited},
+	}
+	for _, test := range tests {
+		b := newStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		})
+		_, err := b.Synonyms("chat")
+		if test.want == nil {
+			if errors.Is(err, ErrBadKey) {
+				t.Errorf("%d %q: mentioning a key should not mean a bad key, got %v", test.status, test.body, err)
+			}
+		} else if !errors.Is(err, test.want) {
+			t.Errorf("%d %q: got %v, want %v", test.status, test.body, err, test.want)
+		}
+		var apiErr *Error
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != test.status {
+			t.Errorf("%d %q: the error should carry the status code, got %#v", test.status, test.body, err)
+		}
+	}
+}
+
+func TestBigHughRetry(t *testing.T) {
+	calls := 0
+	b := newStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"noun": {"syn": ["talk"]}}`))
+	})
+	b.MaxRetries = 2
+	if syns, err := b.Synonyms("chat"); err != nil || len(syns) != 1 {
+		t.Errorf("temporary errors should be retried, got %v, %v", syns, err)
+	}
+
+	calls = 0
+	b.MaxRetries = 1
+	if _, err := b.Synonyms("chat"); err == nil || calls != 2 {
+		t.Errorf("retries should stop after MaxRetries, got %d calls (%v)", calls, err)
+	}
+
+	calls = 0
+	nf := newStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	})
+	nf.MaxRetries = 3
+	nf.Synonyms("chat")
+	if calls != 1 {
+		t.Errorf("not found should not be retried, got %d calls", calls)
+	}
+}
+
+func TestBigHughRetryAfterCap(t *testing.T) {
+	calls := 0
+	b := newStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	b.MaxRetries = 3
+	b.MaxRetryAfter = time.Second
+	start := time.Now()
+	_, err := b.Synonyms("chat")
+	if !errors.Is(err, ErrRateLimited) || calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("long Retry-After should return ErrRateLimited without waiting, got %d calls (%v)", calls, err)
+	}
+}
+
+func TestBigHughTimeout(t *testing.T) {
+	b := newStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	})
+	b.Client = &http.Client{Timeout: 10 * time.Millisecond}
+	_, err := b.Synonyms("chat")
+	if err == nil {
+		t.Fatal("slow responses should time out")
+	}
+	if strings.Contains(err.Error(), "/key/") {
+		t.Error("errors should not include the API key")
+	}
+}
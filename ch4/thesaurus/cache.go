@@ -0,0 +1,230 @@
+package thesaurus
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheOptions はCacheの設定です
+type CacheOptions struct {
+	// Size はメモリに保持する単語の数です。0の場合は1000です
+	Size int
+	// Path を指定すると、結果をディスクにも保存して次回の実行でも使います
+	Path string
+	// TTL は類語が見つかった結果を保持する期間です。0の場合は期限なしです
+	TTL time.Duration
+	// NegativeTTL は類語が見つからなかった結果を保持する期間です。0の場合は保持しません
+	NegativeTTL time.Duration
+}
+
+// Cache は他のThesaurusの結果をキャッシュするThesaurusです。
+// よく使う単語はメモリのLRUに、Pathを指定した場合はすべての結果をディスクに保存します。
+// ErrNotFoundはNegativeTTLの間キャッシュし、それ以外のエラーはキャッシュしません。
+type Cache struct {
+	next Thesaurus
+	opts CacheOptions
+	now  func() time.Time
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+	// diskはディスクに保存されている結果で、起動時に読み込みます
+	disk map[string]cacheEntry
+	out  io.WriteCloser
+	enc  *json.Encoder
+}
+
+type cacheEntry struct {
+	Term    string    `json:"term"`
+	Results []Synonym `json:"results,omitempty"`
+	// NotFound は類語が見つからなかった結果であることを表します
+	NotFound bool      `json:"not_found,omitempty"`
+	Expires  time.Time `json:"expires,omitempty"`
+}
+
+func (e cacheEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// NewCache はnextの結果をキャッシュするCacheを作ります。
+// opts.Pathのファイルは1行1件のJSONで、結果を追記していきます。
+func NewCache(next Thesaurus, opts CacheOptions) (*Cache, error) {
+	if opts.Size <= 0 {
+		opts.Size = 1000
+	}
+	c := &Cache{
+		next:  next,
+		opts:  opts,
+		now:   time.Now,
+		lru:   list.New(),
+		items: map[string]*list.Element{},
+		disk:  map[string]cacheEntry{},
+	}
+	if opts.Path == "" {
+		return c, nil
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load はディスクのキャッシュを読み込み、期限切れの結果を除いて書き直します
+func (c *Cache) load() error {
+	if err := os.MkdirAll(filepath.Dir(c.opts.Path), 0755); err != nil {
+		return err
+	}
+	if f, err := os.Open(c.opts.Path); err == nil {
+		s := bufio.NewScanner(f)
+		s.Buffer(make([]byte, 64*1024), 1024*1024)
+		for s.Scan() {
+			var e cacheEntry
+			// 書き込み途中で止まった行や、結果のない行は読み飛ばします
+			if json.Unmarshal(s.Bytes(), &e) == nil && (len(e.Results) > 0 || e.NotFound) {
+				c.disk[e.Term] = e
+			}
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	now := c.now()
+	tmp := c.opts.Path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for term, e := range c.disk {
+		if e.expired(now) {
+			delete(c.disk, term)
+			continue
+		}
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.opts.Path); err != nil {
+		return err
+	}
+	if c.out, err = os.OpenFile(c.opts.Path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+	c.enc = json.NewEncoder(c.out)
+	return nil
+}
+
+// Synonyms はキャッシュにあればその結果を、なければnextに問い合わせた結果を返します
+func (c *Cache) Synonyms(term string) ([]string, error) {
+	return synonymsOf(c.Lookup(term))
+}
+
+// Lookup はキャッシュにあればその結果を、なければnextに問い合わせた結果を品詞と関係と一緒に返します
+func (c *Cache) Lookup(term string) ([]Synonym, error) {
+	key := strings.ToLower(strings.TrimSpace(term))
+	if syns, ok := c.get(key); ok {
+		if len(syns) == 0 {
+			return nil, ErrNotFound
+		}
+		return syns, nil
+	}
+	syns, err := Lookup(c.next, term)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return syns, err
+	}
+	c.put(key, syns)
+	return syns, err
+}
+
+func (c *Cache) get(key string) ([]Synonym, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(cacheEntry)
+		if !e.expired(now) {
+			c.lru.MoveToFront(el)
+			return copySynonyms(e.Results), true
+		}
+		c.lru.Remove(el)
+		delete(c.items, key)
+	}
+	if e, ok := c.disk[key]; ok {
+		if !e.expired(now) {
+			c.remember(e)
+			return copySynonyms(e.Results), true
+		}
+		delete(c.disk, key)
+	}
+	return nil, false
+}
+
+func (c *Cache) put(key string, syns []Synonym) {
+	ttl := c.opts.TTL
+	if len(syns) == 0 {
+		if c.opts.NegativeTTL <= 0 {
+			return
+		}
+		ttl = c.opts.NegativeTTL
+	}
+	e := cacheEntry{Term: key, Results: copySynonyms(syns), NotFound: len(syns) == 0}
+	if ttl > 0 {
+		e.Expires = c.now().Add(ttl)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remember(e)
+	if c.enc != nil {
+		c.disk[key] = e
+		// 保存に失敗してもメモリのキャッシュは使えるので、エラーは無視します
+		c.enc.Encode(e)
+	}
+}
+
+// remember はeをLRUの先頭に置き、Sizeを超えた古い結果を捨てます
+func (c *Cache) remember(e cacheEntry) {
+	if el, ok := c.items[e.Term]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[e.Term] = c.lru.PushFront(e)
+	for c.lru.Len() > c.opts.Size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(cacheEntry).Term)
+	}
+}
+
+// Close はディスクのキャッシュのファイルを閉じます
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.out == nil {
+		return nil
+	}
+	err := c.out.Close()
+	c.out, c.enc = nil, nil
+	return err
+}
+
+func copySynonyms(s []Synonym) []Synonym {
+	if s == nil {
+		return nil
+	}
+	out := make([]Synonym, len(s))
+	copy(out, s)
+	return out
+}
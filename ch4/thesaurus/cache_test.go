@@ -0,0 +1,137 @@
+package thesaurus
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingThesaurus は問い合わせの回数を数えるテスト用のThesaurusです
+type countingThesaurus struct {
+	words map[string][]string
+	calls map[string]int
+	err   error
+}
+
+func (t *countingThesaurus) Synonyms(term string) ([]string, error) {
+	if t.calls == nil {
+		t.calls = map[string]int{}
+	}
+	t.calls[term]++
+	if t.err != nil {
+		return nil, t.err
+	}
+	return t.words[term], nil
+}
+
+func newCountingThesaurus() *countingThesaurus {
+	return &countingThesaurus{words: map[string][]string{
+		"chat":  {"talk"},
+		"speak": {"talk", "utter"},
+		"go":    {"move"},
+	}}
+}
+
+func TestCacheLRU(t *testing.T) {
+	next := newCountingThesaurus()
+	c, err := NewCache(next, CacheOptions{Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, term := range []string{"chat", "chat", "speak", "go", "chat"} {
+		c.Synonyms(term)
+	}
+	// chatはgoを追加したときにLRUから追い出されます
+	if next.calls["chat"] != 2 || next.calls["speak"] != 1 || next.calls["go"] != 1 {
+		t.Errorf("unexpected calls: %v", next.calls)
+	}
+	syns, _ := c.Synonyms("chat")
+	syns[0] = "modified"
+	if syns, _ := c.Synonyms("chat"); syns[0] != "talk" {
+		t.Error("callers should not be able to modify cached results")
+	}
+}
+
+func TestCacheNegativeAndErrors(t *testing.T) {
+	next := newCountingThesaurus()
+	c, _ := NewCache(next, CacheOptions{NegativeTTL: time.Hour})
+	now := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	c.Synonyms("unknown")
+	c.Synonyms("unknown")
+	if next.calls["unknown"] != 1 {
+		t.Errorf("not found words should be cached, got %d calls", next.calls["unknown"])
+	}
+	now = now.Add(2 * time.Hour)
+	c.Synonyms("unknown")
+	if next.calls["unknown"] != 2 {
+		t.Errorf("negative results should expire, got %d calls", next.calls["unknown"])
+	}
+
+	next.err = errors.New("network down")
+	if _, err := c.Synonyms("error"); err == nil {
+		t.Fatal("errors should be returned")
+	}
+	next.err = nil
+	c.Synonyms("error")
+	if next.calls["error"] != 2 {
+		t.Errorf("errors should not be cached, got %d calls", next.calls["error"])
+	}
+}
+
+func TestCacheDisk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "synonyms.jsonl")
+	// 読み込むときの期限切れの判定は実際の時刻で行われます
+	now := time.Now()
+	opts := CacheOptions{Path: path, TTL: 24 * time.Hour, NegativeTTL: time.Hour}
+
+	next := newCountingThesaurus()
+	c, err := NewCache(next, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.now = func() time.Time { return now }
+	c.Synonyms("chat")
+	c.Synonyms("unknown")
+	c.Close()
+
+	// 次の実行ではディスクから読み込むので問い合わせません
+	next = newCountingThesaurus()
+	c, err = NewCache(next, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if syns, _ := c.Synonyms("Chat"); len(syns) != 1 || next.calls["Chat"] != 0 {
+		t.Errorf("results should be loaded from disk, got %v (%v)", syns, next.calls)
+	}
+	c.Synonyms("unknown")
+	if next.calls["unknown"] != 1 {
+		t.Errorf("expired negative results should be looked up again, got %v", next.calls)
+	}
+	c.Close()
+}
+
+func TestCacheLookup(t *testing.T) {
+	file, err := ParseMyThes(strings.NewReader(myThesData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "synonyms.jsonl")
+	c, _ := NewCache(file, CacheOptions{Path: path})
+	want, _ := file.Lookup("chat")
+	c.Lookup("chat")
+	c.Close()
+
+	c, _ = NewCache(&countingThesaurus{}, CacheOptions{Path: path})
+	defer c.Close()
+	if got, _ := c.Lookup("chat"); !reflect.DeepEqual(got, want) {
+		t.Errorf("parts of speech and relations should be cached, got %v, want %v", got, want)
+	}
+	if syns, _ := c.Synonyms("chat"); len(syns) != 3 {
+		t.Errorf("Synonyms should only return synonyms, got %v", syns)
+	}
+}
@@ -0,0 +1,160 @@
+package thesaurus
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// File はディスク上の類語辞書を読み込んで使うThesaurusです。
+// ネットワークなしで動き、結果も毎回同じになります。
+//
+// 次の2つの形式に対応しています。
+//
+//   - Moby形式 (mthesaur.txt): 1行に "単語,類語,類語,..."
+//   - WordNetから作られたMyThes形式 (th_en_US.dat):
+//     1行目が文字コード名で、"単語|意味の数" の行の後に "(品詞)|類語|類語|..." が意味の数だけ続く。
+//     "hot (antonym)" のように付いている注記から関係を判断します
+type File struct {
+	words map[string][]Synonym
+}
+
+// myThesPOS はMyThes形式の品詞の表記をBigHughと同じ名前にします
+var myThesPOS = map[string]string{
+	"noun": "noun",
+	"verb": "verb",
+	"adj":  "adjective",
+	"adv":  "adverb",
+}
+
+// myThesRelations はMyThes形式の注記と関係の対応です
+var myThesRelations = map[string]Relation{
+	"antonym":      Ant,
+	"similar term": Sim,
+	"related term": Rel,
+	"generic term": Rel,
+}
+
+// LoadFile はpathの辞書を形式を判別して読み込みます
+func LoadFile(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	first, err := br.Peek(64)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	line := string(first)
+	if i := strings.IndexAny(line, "\r\n"); i >= 0 {
+		line = line[:i]
+	}
+	// MyThes形式の1行目は "UTF-8" や "ISO8859-1" のような文字コード名だけです
+	if line != "" && !strings.ContainsAny(line, ",|") {
+		return ParseMyThes(br)
+	}
+	return ParseMoby(br)
+}
+
+// ParseMoby はMoby形式の辞書を読み込みます
+func ParseMoby(r io.Reader) (*File, error) {
+	t := &File{words: map[string][]Synonym{}}
+	s := newLineScanner(r)
+	for s.Scan() {
+		fields := strings.Split(s.Text(), ",")
+		if len(fields) < 2 {
+			continue
+		}
+		for _, syn := range fields[1:] {
+			t.add(fields[0], Synonym{Word: syn, Relation: Syn})
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ParseMyThes はMyThes形式の辞書を読み込みます
+func ParseMyThes(r io.Reader) (*File, error) {
+	t := &File{words: map[string][]Synonym{}}
+	s := newLineScanner(r)
+	// 1行目は文字コード名なので読み飛ばします
+	s.Scan()
+	lineNo := 1
+	for s.Scan() {
+		lineNo++
+		entry := strings.Split(s.Text(), "|")
+		if len(entry) != 2 {
+			return nil, fmt.Errorf("thesaurus: %d行目: \"単語|意味の数\" の形式ではありません", lineNo)
+		}
+		n, err := strconv.Atoi(entry[1])
+		if err != nil {
+			return nil, fmt.Errorf("thesaurus: %d行目: 意味の数が正しくありません: %v", lineNo, err)
+		}
+		for i := 0; i < n; i++ {
+			if !s.Scan() {
+				return nil, errors.New("thesaurus: " + entry[0] + " の意味が足りません")
+			}
+			lineNo++
+			fields := strings.Split(s.Text(), "|")
+			// 最初の項目は "(noun)" のような品詞です
+			pos := myThesPOS[strings.Trim(fields[0], "()")]
+			for _, word := range fields[1:] {
+				rel := Syn
+				if i := strings.LastIndex(word, " ("); i >= 0 && strings.HasSuffix(word, ")") {
+					if r, ok := myThesRelations[word[i+2:len(word)-1]]; ok {
+						word, rel = word[:i], r
+					}
+				}
+				t.add(entry[0], Synonym{Word: word, PartOfSpeech: pos, Relation: rel})
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	return s
+}
+
+func (t *File) add(term string, syn Synonym) {
+	key := strings.ToLower(strings.TrimSpace(term))
+	if syn.Word = strings.TrimSpace(syn.Word); syn.Word == "" {
+		return
+	}
+	for _, s := range t.words[key] {
+		if s == syn {
+			return
+		}
+	}
+	t.words[key] = append(t.words[key], syn)
+}
+
+// Synonyms はtermの類語を返します。大文字と小文字は区別しません。
+// 辞書にない単語の場合はErrNotFoundを返します。
+func (t *File) Synonyms(term string) ([]string, error) {
+	return synonymsOf(t.Lookup(term))
+}
+
+// Lookup はtermに関係する単語を品詞と関係と一緒に返します
+func (t *File) Lookup(term string) ([]Synonym, error) {
+	syns, ok := t.words[strings.ToLower(strings.TrimSpace(term))]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	out := make([]Synonym, len(syns))
+	copy(out, syns)
+	return out, nil
+}
@@ -0,0 +1,83 @@
+package thesaurus
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	_ Lookuper = (*File)(nil)
+	_ Lookuper = (*BigHugh)(nil)
+	_ Lookuper = (*Cache)(nil)
+)
+
+const mobyData = `chat,talk,conversation,gossip
+Talk,chat,speak
+`
+
+const myThesData = `UTF-8
+chat|2
+(noun)|talk|conversation
+(verb)|talk|gossip|listen (antonym)
+speak|1
+(verb)|talk|utter
+`
+
+func TestParseMoby(t *testing.T) {
+	th, err := ParseMoby(strings.NewReader(mobyData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	syns, _ := th.Synonyms("Chat")
+	if want := []string{"talk", "conversation", "gossip"}; !reflect.DeepEqual(syns, want) {
+		t.Errorf("Synonyms(Chat) = %v, want %v", syns, want)
+	}
+	if syns, err := th.Synonyms("unknown"); err != ErrNotFound {
+		t.Errorf("unknown words should return ErrNotFound, got %v, %v", syns, err)
+	}
+}
+
+func TestParseMyThes(t *testing.T) {
+	th, err := ParseMyThes(strings.NewReader(myThesData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	syns, _ := th.Synonyms("chat")
+	if want := []string{"talk", "conversation", "gossip"}; !reflect.DeepEqual(syns, want) {
+		t.Errorf("Synonyms(chat) = %v, want %v", syns, want)
+	}
+	found, _ := th.Lookup("chat")
+	want := []Synonym{
+		{"talk", "noun", Syn},
+		{"conversation", "noun", Syn},
+		{"talk", "verb", Syn},
+		{"gossip", "verb", Syn},
+		{"listen", "verb", Ant},
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("Lookup(chat) = %v, want %v", found, want)
+	}
+	if _, err := ParseMyThes(strings.NewReader("UTF-8\nchat|2\n(noun)|talk\n")); err == nil {
+		t.Error("truncated entries should be an error")
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{"moby.txt": mobyData, "th_en_US.dat": myThesData} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		th, err := LoadFile(path)
+		if err != nil {
+			t.Fatalf("LoadFile(%s): %s", name, err)
+		}
+		if syns, _ := th.Synonyms("chat"); len(syns) != 3 {
+			t.Errorf("LoadFile(%s): unexpected synonyms %v", name, syns)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package thesaurus
+
+import "errors"
+
+// Thesaurus は単語の類語を調べるためのインターフェースです。
+// BigHughのようなWeb APIのほか、Fileのようなオフラインの辞書も使えます。
+// 類語が見つからない場合はErrNotFoundを返します。
+type Thesaurus interface {
+	Synonyms(term string) ([]string, error)
+}
+
+// Lookuper は品詞や単語どうしの関係の付いた結果を返せるThesaurusです
+type Lookuper interface {
+	Thesaurus
+	Lookup(term string) ([]Synonym, error)
+}
+
+var (
+	// ErrNotFound は単語が辞書にないことを表します
+	ErrNotFound = errors.New("thesaurus: 類語が見つかりません")
+	// ErrRateLimited はAPIの利用回数の上限に達したことを表します
+	ErrRateLimited = errors.New("thesaurus: APIの利用回数の上限に達しました")
+	// ErrBadKey はAPIキーが正しくないか、無効になっていることを表します
+	ErrBadKey = errors.New("thesaurus: APIキーが正しくありません")
+)
+
+// Relation は調べた単語と結果の単語の関係です
+type Relation string
+
+const (
+	// Syn は類語
+	Syn Relation = "syn"
+	// Ant は反意語
+	Ant Relation = "ant"
+	// Rel は関連語
+	Rel Relation = "rel"
+	// Sim は似た意味の語
+	Sim Relation = "sim"
+	// Usr はユーザーが提案した語
+	Usr Relation = "usr"
+)
+
+// relations は結果を並べる順番です
+var relations = []Relation{Syn, Sim, Rel, Usr, Ant}
+
+// Synonym は調べた単語に関係する単語と、その品詞と関係です
+type Synonym struct {
+	Word string `json:"word"`
+	// PartOfSpeech は "noun", "verb", "adjective", "adverb" のような品詞です。分からない場合は空です
+	PartOfSpeech string   `json:"pos,omitempty"`
+	Relation     Relation `json:"rel"`
+}
+
+// Lookup はtがLookuperならその結果を、そうでなければ類語を品詞なしのSynとして返します
+func Lookup(t Thesaurus, term string) ([]Synonym, error) {
+	if l, ok := t.(Lookuper); ok {
+		return l.Lookup(term)
+	}
+	words, err := t.Synonyms(term)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, ErrNotFound
+	}
+	syns := make([]Synonym, len(words))
+	for i, w := range words {
+		syns[i] = Synonym{Word: w, Relation: Syn}
+	}
+	return syns, nil
+}
+
+// Words は結果のうちRelationがSynの単語を、重複を除いて返します
+func Words(syns []Synonym) []string {
+	var words []string
+	seen := map[string]bool{}
+	for _, s := range syns {
+		if s.Relation == Syn && !seen[s.Word] {
+			seen[s.Word] = true
+			words = append(words, s.Word)
+		}
+	}
+	return words
+}
+
+// synonymsOf はLookupの結果から類語を取り出します。類語がなければErrNotFoundを返します
+func synonymsOf(syns []Synonym, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	words := Words(syns)
+	if len(words) == 0 {
+		return nil, ErrNotFound
+	}
+	return words, nil
+}